@@ -0,0 +1,96 @@
+package cimbl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Aggregation levels
+const (
+	ByHost   = "host"
+	ByDomain = "domain"
+)
+
+// groupKey is the host or registered domain of u, "" if it can not be parsed
+func groupKey(u, by string) string {
+	c, err := Canonicalize(u)
+	if err != nil {
+		return ""
+	}
+	if by == ByHost {
+		return c.Host
+	}
+	return c.Domain
+}
+
+// Groups returns the URLs per host or registered domain
+func (r *Results) Groups(by string) map[string][]string {
+	groups := map[string][]string{}
+	for u := range r.URLs {
+		if key := groupKey(u, by); key != "" {
+			groups[key] = append(groups[key], u)
+		}
+	}
+	for _, all := range groups {
+		sort.Strings(all)
+	}
+	return groups
+}
+
+// Aggregate replaces the URLs of every host or registered domain having at least
+// threshold of them by a single domain-level block, 0 means no aggregation.
+func (r *Results) Aggregate(threshold int, by string) (*Results, error) {
+	if threshold <= 0 {
+		return r, nil
+	}
+
+	switch by {
+	case "":
+		by = ByDomain
+	case ByHost, ByDomain:
+	default:
+		return r, fmt.Errorf("unknown aggregation %s", by)
+	}
+
+	for key, all := range r.Groups(by) {
+		if len(all) < threshold {
+			continue
+		}
+		verbose("aggregating %d URLs into %s", len(all), key)
+		for _, u := range all {
+			delete(r.URLs, u)
+		}
+		r.AddAggregated(key, all...)
+	}
+	return r, nil
+}
+
+// AddAggregated records that urls are blocked through key
+func (r *Results) AddAggregated(key string, urls ...string) *Results {
+	if r.Aggregated == nil {
+		r.Aggregated = map[string][]string{}
+	}
+	seen := map[string]bool{}
+	for _, u := range r.Aggregated[key] {
+		seen[u] = true
+	}
+	for _, u := range urls {
+		if !seen[u] {
+			seen[u] = true
+			r.Aggregated[key] = append(r.Aggregated[key], u)
+		}
+	}
+	sort.Strings(r.Aggregated[key])
+	return r
+}
+
+// aggregatedBy is the reverse index, URL to domain-level block
+func (r *Results) aggregatedBy() map[string]string {
+	index := map[string]string{}
+	for key, all := range r.Aggregated {
+		for _, u := range all {
+			index[u] = key
+		}
+	}
+	return index
+}
@@ -0,0 +1,94 @@
+package cimbl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func someURLs() *Results {
+	r := NewResults()
+	r.Add("url", "http://www.example.com/a.php")
+	r.Add("url", "http://www.example.com/b.php")
+	r.Add("url", "http://cdn.example.com/c.js")
+	r.Add("url", "http://www.example.co.uk/d.php")
+	return r
+}
+
+func TestResults_Groups(t *testing.T) {
+	r := someURLs()
+
+	byDomain := map[string][]string{
+		"example.com": {
+			"http://cdn.example.com/c.js",
+			"http://www.example.com/a.php",
+			"http://www.example.com/b.php",
+		},
+		"example.co.uk": {"http://www.example.co.uk/d.php"},
+	}
+	assert.Equal(t, byDomain, r.Groups(ByDomain))
+
+	byHost := r.Groups(ByHost)
+	assert.Len(t, byHost, 3)
+	assert.Len(t, byHost["www.example.com"], 2)
+}
+
+func TestResults_Aggregate(t *testing.T) {
+	r := someURLs()
+
+	_, err := r.Aggregate(3, "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"http://www.example.co.uk/d.php": true}, r.URLs)
+	assert.Len(t, r.Aggregated["example.com"], 3)
+}
+
+func TestResults_AggregateHost(t *testing.T) {
+	r := someURLs()
+
+	_, err := r.Aggregate(2, ByHost)
+	require.NoError(t, err)
+	assert.Len(t, r.URLs, 2)
+	assert.Equal(t, []string{"http://www.example.com/a.php", "http://www.example.com/b.php"},
+		r.Aggregated["www.example.com"])
+}
+
+func TestResults_AggregateNone(t *testing.T) {
+	r := someURLs()
+
+	_, err := r.Aggregate(0, ByDomain)
+	require.NoError(t, err)
+	assert.Len(t, r.URLs, 4)
+	assert.Empty(t, r.Aggregated)
+
+	_, err = r.Aggregate(10, ByDomain)
+	require.NoError(t, err)
+	assert.Len(t, r.URLs, 4)
+	assert.Empty(t, r.Aggregated)
+}
+
+func TestResults_AggregateBad(t *testing.T) {
+	_, err := someURLs().Aggregate(2, "tld")
+	assert.Error(t, err)
+}
+
+func TestResults_AddAggregated(t *testing.T) {
+	r := &Results{}
+	r.AddAggregated("example.com", "http://example.com/b", "http://example.com/a")
+	r.AddAggregated("example.com", "http://example.com/a")
+	assert.Equal(t, []string{"http://example.com/a", "http://example.com/b"}, r.Aggregated["example.com"])
+}
+
+func TestResults_PassedByAggregated(t *testing.T) {
+	r := someURLs()
+	for u := range r.URLs {
+		r.AddVerdict(u, "a", Verdict{Action: u})
+	}
+
+	_, err := r.Aggregate(3, ByDomain)
+	require.NoError(t, err)
+
+	urls, domains := r.PassedBy("a")
+	assert.Equal(t, map[string]bool{"http://www.example.co.uk/d.php": true}, urls)
+	assert.Equal(t, map[string]bool{"example.com": true}, domains)
+}
@@ -0,0 +1,252 @@
+package cimbl
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// AllowConfig lists what must never be blocked
+type AllowConfig struct {
+	// URLs are matched exactly, after canonicalisation, https ones protect their host
+	URLs []string
+	// Domains match themselves and all their subdomains
+	Domains []string
+	// Networks are CIDR ranges, matched against IP hosts
+	Networks []string
+	// Filenames are shell patterns, case-insensitive
+	Filenames []string
+}
+
+// Allowlist is the compiled version of AllowConfig
+type Allowlist struct {
+	urls map[string]bool
+	// https are the hosts of the https URLs, we block them at the domain level
+	https     map[string]string
+	domains   []string
+	networks  []*net.IPNet
+	filenames []string
+}
+
+// NewAllowlist checks and compiles the configuration
+func NewAllowlist(cnf AllowConfig) (*Allowlist, error) {
+	a := &Allowlist{urls: map[string]bool{}, https: map[string]string{}}
+
+	for _, u := range cnf.URLs {
+		a.urls[canonURL(u)] = true
+		if host, ok := httpsHost(canonURL(u)); ok {
+			a.https[host] = canonURL(u)
+		}
+	}
+	for _, d := range cnf.Domains {
+		host, err := canonHost(strings.TrimPrefix(d, "*."))
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain %s", d)
+		}
+		a.domains = append(a.domains, host)
+	}
+	for _, n := range cnf.Networks {
+		// A single address is a /32 or /128
+		if ip := net.ParseIP(n); ip != nil {
+			if ip.To4() != nil {
+				n += "/32"
+			} else {
+				n += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s", n)
+		}
+		a.networks = append(a.networks, ipnet)
+	}
+	for _, f := range cnf.Filenames {
+		if _, err := filepath.Match(f, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s", f)
+		}
+		a.filenames = append(a.filenames, strings.ToLower(f))
+	}
+	return a, nil
+}
+
+// Host returns why host must not be blocked, "" if it can be
+func (a *Allowlist) Host(host string) string {
+	if a == nil {
+		return ""
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range a.networks {
+			if n.Contains(ip) {
+				return "network " + n.String()
+			}
+		}
+		return ""
+	}
+
+	for _, d := range a.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return "domain " + d
+		}
+	}
+	return ""
+}
+
+// URL returns why u must not be blocked, "" if it can be
+func (a *Allowlist) URL(u string) string {
+	if a == nil {
+		return ""
+	}
+
+	c, err := Canonicalize(u)
+	if err != nil {
+		return ""
+	}
+	if a.urls[c.URL] {
+		return "url"
+	}
+	return a.Host(c.Host)
+}
+
+// Domain returns why the domain d must not be blocked, "" if it can be.  Blocking it would
+// block the https URLs we allow on it too.
+func (a *Allowlist) Domain(d string) string {
+	if a == nil {
+		return ""
+	}
+
+	if u, ok := a.https[strings.TrimSuffix(strings.ToLower(d), ".")]; ok {
+		return "url " + u
+	}
+	return a.Host(d)
+}
+
+// Under returns why nothing may be blocked at the level of key, "" if it can be.  Blocking a
+// domain would also block the allowed domains and URLs below it.
+func (a *Allowlist) Under(key string) string {
+	if a == nil {
+		return ""
+	}
+
+	if why := a.Domain(key); why != "" {
+		return why
+	}
+	key = strings.TrimSuffix(strings.ToLower(key), ".")
+	below := func(host string) bool {
+		return host == key || strings.HasSuffix(host, "."+key)
+	}
+	for _, d := range a.domains {
+		if below(d) {
+			return "domain " + d
+		}
+	}
+	for u := range a.urls {
+		if c, err := Canonicalize(u); err == nil && below(c.Host) {
+			return "url " + u
+		}
+	}
+	return ""
+}
+
+// Network returns why the range cidr must not be blocked, "" if it can be
+func (a *Allowlist) Network(cidr string) string {
+	if a == nil {
+		return ""
+	}
+
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ""
+	}
+	for _, n := range a.networks {
+		if n.Contains(ip) || ipnet.Contains(n.IP) {
+			return "network " + n.String()
+		}
+	}
+	return ""
+}
+
+// Filename returns why fn must not be blocked, "" if it can be
+func (a *Allowlist) Filename(fn string) string {
+	if a == nil {
+		return ""
+	}
+
+	for _, f := range a.filenames {
+		if ok, _ := filepath.Match(f, strings.ToLower(fn)); ok {
+			return "filename " + f
+		}
+	}
+	return ""
+}
+
+// Allow removes everything the allowlist protects from r, keeping them in Allowed for review
+func (r *Results) Allow(a *Allowlist) *Results {
+	if a == nil {
+		return r
+	}
+
+	for p := range r.Paths {
+		if why := a.Filename(p); why != "" {
+			delete(r.Paths, p)
+			r.AddAllowed(p, why)
+		}
+	}
+	// Do not block a domain over what we allow, its URLs are checked one by one again
+	for key, all := range r.Aggregated {
+		if why := a.Under(key); why != "" {
+			verbose("not aggregating %s: %s", key, why)
+			for _, u := range all {
+				r.Add("url", u)
+			}
+			delete(r.Aggregated, key)
+		}
+	}
+	for u := range r.URLs {
+		if why := a.URL(u); why != "" {
+			delete(r.URLs, u)
+			r.AddAllowed(u, why)
+		}
+	}
+	for d := range r.Domains {
+		if why := a.Domain(d); why != "" {
+			delete(r.Domains, d)
+			r.AddAllowed(d, why)
+		}
+	}
+	for n := range r.Networks {
+		if why := a.Network(n); why != "" {
+			delete(r.Networks, n)
+			r.AddAllowed(n, why)
+		}
+	}
+	for u := range r.Unchecked {
+		if why := a.URL(u); why != "" {
+			delete(r.Unchecked, u)
+			r.AddAllowed(u, why)
+		}
+	}
+	for h := range r.DNS {
+		if a.Host(h) != "" {
+			delete(r.DNS, h)
+		}
+	}
+	// Verdicts are kept, PassedBy skips the allowed ones
+	for u := range r.Verdicts {
+		if why := a.URL(u); why != "" {
+			r.AddAllowed(u, why)
+		}
+	}
+	return r
+}
+
+// AddAllowed records that e was not reported because of the allowlist
+func (r *Results) AddAllowed(e, why string) *Results {
+	if r.Allowed == nil {
+		r.Allowed = map[string]string{}
+	}
+	r.Allowed[e] = why
+	return r
+}
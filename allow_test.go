@@ -0,0 +1,165 @@
+package cimbl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAllowlist(t *testing.T) *Allowlist {
+	a, err := NewAllowlist(AllowConfig{
+		URLs:      []string{"hxxp://www.example[.]net:80/search.php", "https://login.example.org/"},
+		Domains:   []string{"microsoft.com", "*.akamaized.net"},
+		Networks:  []string{"10.0.0.0/8", "192.0.2.1"},
+		Filenames: []string{"*.dll", "setup.exe"},
+	})
+	require.NoError(t, err)
+	return a
+}
+
+func TestNewAllowlist_Bad(t *testing.T) {
+	_, err := NewAllowlist(AllowConfig{Networks: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+
+	_, err = NewAllowlist(AllowConfig{Domains: []string{"exa mple.com"}})
+	assert.Error(t, err)
+
+	_, err = NewAllowlist(AllowConfig{Filenames: []string{"[a"}})
+	assert.Error(t, err)
+}
+
+func TestAllowlist_Host(t *testing.T) {
+	a := testAllowlist(t)
+
+	td := []struct{ in, out string }{
+		{"microsoft.com", "domain microsoft.com"},
+		{"www.Microsoft.com.", "domain microsoft.com"},
+		{"notmicrosoft.com", ""},
+		{"a1.akamaized.net", "domain akamaized.net"},
+		{"10.1.2.3", "network 10.0.0.0/8"},
+		{"192.0.2.1", "network 192.0.2.1/32"},
+		{"192.0.2.2", ""},
+		{"example.com", ""},
+	}
+	for _, d := range td {
+		assert.Equal(t, d.out, a.Host(d.in), d.in)
+	}
+}
+
+func TestAllowlist_URL(t *testing.T) {
+	a := testAllowlist(t)
+
+	assert.Equal(t, "url", a.URL("http://www.example.net/search.php"))
+	assert.Equal(t, "", a.URL("http://www.example.net/other.php"))
+	assert.Equal(t, "domain microsoft.com", a.URL("https://update.microsoft.com/foo"))
+	assert.Equal(t, "network 10.0.0.0/8", a.URL("http://10.0.0.1:8080/foo"))
+	assert.Equal(t, "", a.URL("ftp://microsoft.com/"))
+}
+
+func TestAllowlist_Domain(t *testing.T) {
+	a := testAllowlist(t)
+
+	assert.Equal(t, "url https://login.example.org/", a.Domain("login.example.org"))
+	assert.Equal(t, "domain microsoft.com", a.Domain("update.microsoft.com"))
+	assert.Equal(t, "", a.Domain("www.example.org"))
+	// Only https URLs are blocked at the domain level
+	assert.Equal(t, "", a.Domain("www.example.net"))
+}
+
+func TestAllowlist_Network(t *testing.T) {
+	a := testAllowlist(t)
+
+	assert.Equal(t, "network 10.0.0.0/8", a.Network("10.1.0.0/16"))
+	assert.Equal(t, "network 10.0.0.0/8", a.Network("0.0.0.0/0"))
+	assert.Equal(t, "network 192.0.2.1/32", a.Network("192.0.2.0/24"))
+	assert.Equal(t, "", a.Network("203.0.113.0/24"))
+	assert.Equal(t, "", a.Network("bogus"))
+}
+
+func TestAllowlist_Filename(t *testing.T) {
+	a := testAllowlist(t)
+
+	assert.Equal(t, "filename *.dll", a.Filename("Kernel32.DLL"))
+	assert.Equal(t, "filename setup.exe", a.Filename("setup.exe"))
+	assert.Equal(t, "", a.Filename("evil.exe"))
+}
+
+func TestAllowlist_Nil(t *testing.T) {
+	var a *Allowlist
+
+	assert.Equal(t, "", a.Host("microsoft.com"))
+	assert.Equal(t, "", a.URL("http://microsoft.com/"))
+	assert.Equal(t, "", a.Filename("foo.dll"))
+}
+
+func TestResults_Allow(t *testing.T) {
+	r := NewResults()
+	r.Add("url", "http://www.example.net/search.php")
+	r.Add("url", "http://www.example.com/malware.php")
+	r.Add("domain", "update.microsoft.com")
+	NewURL("https://login.example.org/").AddTo(r)
+	r.Add("filename", "foo.dll")
+	r.Add("filename", "evil.exe")
+	r.AddDNS("update.microsoft.com", DNSResolves)
+	r.AddVerdict("http://www.example.net/search.php", "a", Verdict{Action: "http://www.example.net/search.php"})
+
+	r.Allow(testAllowlist(t))
+
+	assert.Equal(t, map[string]bool{"http://www.example.com/malware.php": true}, r.URLs)
+	assert.Empty(t, r.Domains)
+	assert.Equal(t, map[string]bool{"evil.exe": true}, r.Paths)
+	assert.Empty(t, r.DNS)
+	assert.Equal(t, map[string]string{
+		"http://www.example.net/search.php": "url",
+		"update.microsoft.com":              "domain microsoft.com",
+		"login.example.org":                 "url https://login.example.org/",
+		"foo.dll":                           "filename *.dll",
+	}, r.Allowed)
+
+	urls, _ := r.PassedBy("a")
+	assert.Empty(t, urls)
+}
+
+func TestAllowlist_Under(t *testing.T) {
+	a := testAllowlist(t)
+
+	assert.Equal(t, "url http://www.example.net/search.php", a.Under("example.net"))
+	assert.Equal(t, "url https://login.example.org/", a.Under("example.org"))
+	assert.Equal(t, "domain microsoft.com", a.Under("update.microsoft.com"))
+	assert.Equal(t, "", a.Under("example.com"))
+}
+
+func TestResults_AllowAggregated(t *testing.T) {
+	r := NewResults()
+	for _, u := range []string{
+		"http://www.example.net/search.php",
+		"http://a.example.net/1",
+		"http://b.example.net/2",
+		"http://c.example.net/3",
+	} {
+		r.Add("url", u)
+	}
+	_, err := r.Aggregate(3, ByDomain)
+	require.NoError(t, err)
+	require.Contains(t, r.Aggregated, "example.net")
+
+	r.Allow(testAllowlist(t))
+
+	assert.Empty(t, r.Aggregated)
+	assert.Equal(t, map[string]bool{
+		"http://a.example.net/1": true,
+		"http://b.example.net/2": true,
+		"http://c.example.net/3": true,
+	}, r.URLs)
+	assert.Equal(t, "url", r.Allowed["http://www.example.net/search.php"])
+}
+
+func TestResults_AllowNil(t *testing.T) {
+	r := NewResults()
+	r.Add("domain", "microsoft.com")
+
+	r.Allow(nil)
+	assert.Len(t, r.Domains, 1)
+	assert.Empty(t, r.Allowed)
+}
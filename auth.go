@@ -0,0 +1,364 @@
+package cimbl
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Proxy authentication schemes
+const (
+	AuthBasic     = "basic"
+	AuthNTLM      = "ntlm"
+	AuthNegotiate = "negotiate"
+)
+
+var (
+	// Default helpers for the connection-oriented schemes, Samba's ntlm_auth like Squid/cntlm
+	defaultHelpers = map[string]string{
+		AuthNTLM:      "ntlm_auth --helper-protocol=ntlmssp-client-1",
+		AuthNegotiate: "ntlm_auth --helper-protocol=gss-spnego-client",
+	}
+)
+
+// Authenticator creates a new handshake for each request needing authentication
+type Authenticator interface {
+	Scheme() string
+	Handshake() (Handshake, error)
+}
+
+// Handshake returns the Proxy-Authorization value for each leg, challenge being empty on the first one
+type Handshake interface {
+	Next(challenge string) (string, error)
+	Close() error
+}
+
+// BasicAuth is a one-leg handshake
+type BasicAuth struct {
+	User     string
+	Password string
+}
+
+// Scheme is basic
+func (b BasicAuth) Scheme() string {
+	return AuthBasic
+}
+
+// Handshake is stateless for Basic
+func (b BasicAuth) Handshake() (Handshake, error) {
+	return b, nil
+}
+
+// Next ignores the challenge
+func (b BasicAuth) Next(challenge string) (string, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(b.User + ":" + b.Password))
+	return "Basic " + auth, nil
+}
+
+// Close is a no-op
+func (b BasicAuth) Close() error {
+	return nil
+}
+
+// HelperAuth uses an external helper speaking the ntlm_auth client protocol for NTLM/Negotiate
+type HelperAuth struct {
+	Type     string
+	Command  []string
+	Password string
+}
+
+// Scheme is either ntlm or negotiate
+func (h HelperAuth) Scheme() string {
+	return h.Type
+}
+
+// Handshake starts a new helper
+func (h HelperAuth) Handshake() (Handshake, error) {
+	if len(h.Command) == 0 {
+		return nil, fmt.Errorf("no helper for %s", h.Type)
+	}
+
+	cmd := exec.Command(h.Command[0], h.Command[1:]...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "helper/stdin")
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "helper/stdout")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "helper %s", h.Command[0])
+	}
+
+	hs := &helperHandshake{
+		h:   h,
+		cmd: cmd,
+		in:  in,
+		out: bufio.NewReader(out),
+	}
+	return hs, nil
+}
+
+type helperHandshake struct {
+	h   HelperAuth
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+// talk sends one request to the helper and returns its answer
+func (hs *helperHandshake) talk(req string) (string, string, error) {
+	if _, err := fmt.Fprintf(hs.in, "%s\n", req); err != nil {
+		return "", "", errors.Wrap(err, "helper/write")
+	}
+	line, err := hs.out.ReadString('\n')
+	if err != nil {
+		return "", "", errors.Wrap(err, "helper/read")
+	}
+	all := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(all) == 1 {
+		return all[0], "", nil
+	}
+	return all[0], all[1], nil
+}
+
+// Next is "YR" for the first leg and "TT <challenge>" after
+func (hs *helperHandshake) Next(challenge string) (string, error) {
+	req := "YR"
+	if challenge != "" {
+		req = "TT " + challenge
+	}
+
+	code, token, err := hs.talk(req)
+	if err != nil {
+		return "", err
+	}
+
+	// The helper wants the password first
+	if code == "PW" {
+		pw := base64.StdEncoding.EncodeToString([]byte(hs.h.Password))
+		if code, _, err = hs.talk("PW " + pw); err != nil {
+			return "", err
+		}
+		if code != "OK" {
+			return "", fmt.Errorf("helper refused the password")
+		}
+		if code, token, err = hs.talk(req); err != nil {
+			return "", err
+		}
+	}
+
+	switch code {
+	case "YR", "KK", "AF":
+		return fmt.Sprintf("%s %s", schemeName(hs.h.Type), token), nil
+	}
+	return "", fmt.Errorf("helper error: %s %s", code, token)
+}
+
+// Close stops the helper
+func (hs *helperHandshake) Close() error {
+	hs.in.Close()
+	return hs.cmd.Wait()
+}
+
+// schemeName is how the scheme appears in the headers
+func schemeName(scheme string) string {
+	switch scheme {
+	case AuthNTLM:
+		return "NTLM"
+	case AuthNegotiate:
+		return "Negotiate"
+	}
+	return "Basic"
+}
+
+// challengeFor extracts the token for scheme from the Proxy-Authenticate headers
+func challengeFor(scheme string, header http.Header) string {
+	name := schemeName(scheme)
+	for _, h := range header[http.CanonicalHeaderKey("Proxy-Authenticate")] {
+		if strings.HasPrefix(h, name+" ") {
+			return strings.TrimSpace(strings.TrimPrefix(h, name))
+		}
+	}
+	return ""
+}
+
+// MaxLegs is the longest handshake we accept (NTLM is 3)
+const MaxLegs = 3
+
+// authenticate runs do with the Proxy-Authorization header from pa until we are no longer
+// asked for authentication, nil meaning no authentication.
+func authenticate(pa Authenticator, do func(auth string) (answer, error)) (answer, error) {
+	a, err := do("")
+	if err != nil || pa == nil || a.Code != http.StatusProxyAuthRequired {
+		return a, err
+	}
+
+	hs, err := pa.Handshake()
+	if err != nil {
+		return a, errors.Wrap(err, "handshake")
+	}
+	defer hs.Close()
+
+	challenge := ""
+	for leg := 0; leg < MaxLegs && a.Code == http.StatusProxyAuthRequired; leg++ {
+		token, err := hs.Next(challenge)
+		if err != nil {
+			return a, errors.Wrap(err, "auth")
+		}
+		debug("auth leg %d", leg)
+		if a, err = do(token); err != nil {
+			return a, err
+		}
+		challenge = challengeFor(pa.Scheme(), a.Header)
+		if challenge == "" {
+			break
+		}
+	}
+	return a, nil
+}
+
+// Credentials
+
+// credentials finds the proxy credentials from the configuration, the proxy URL, the
+// dbrc file then the netrc one.
+func credentials(config *Config, proxy *url.URL) (string, string) {
+	if config.ProxyUser != "" {
+		return config.ProxyUser, config.ProxyPassword
+	}
+
+	if proxy != nil && proxy.User != nil {
+		pass, _ := proxy.User.Password()
+		return proxy.User.Username(), pass
+	}
+
+	host := "proxy"
+	if proxy != nil {
+		host = proxy.Hostname()
+	}
+
+	if user, pass, err := readDbrc(rcFile("DBRC", ".dbrc"), host); err == nil {
+		return user, pass
+	}
+	if user, pass, err := readNetrc(rcFile("NETRC", ".netrc"), host); err == nil {
+		return user, pass
+	}
+	return "", ""
+}
+
+// rcFile is either from the environment or in $HOME
+func rcFile(env, name string) string {
+	if fn := os.Getenv(env); fn != "" {
+		return fn
+	}
+	return filepath.Join(os.Getenv("HOME"), name)
+}
+
+// readDbrc looks for "proxy <user> <password> [type]" or the same with the proxy host
+func readDbrc(file, host string) (string, string, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return "", "", err
+	}
+	defer fh.Close()
+
+	s := bufio.NewScanner(fh)
+	for s.Scan() {
+		all := strings.Fields(s.Text())
+		if len(all) < 3 || strings.HasPrefix(all[0], "#") {
+			continue
+		}
+		if all[0] == "proxy" || all[0] == host {
+			return all[1], all[2], nil
+		}
+	}
+	return "", "", fmt.Errorf("no proxy entry in %s", file)
+}
+
+// readNetrc looks for "machine <host> login <user> password <password>", "default" is accepted
+func readNetrc(file, host string) (string, string, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", "", err
+	}
+
+	var (
+		user, pass string
+		found      bool
+	)
+
+	words := strings.Fields(string(buf))
+	for i := 0; i < len(words); i++ {
+		switch words[i] {
+		case "machine":
+			if found {
+				return user, pass, nil
+			}
+			if i+1 < len(words) {
+				i++
+				found = words[i] == host || words[i] == "proxy"
+			}
+		case "default":
+			if found {
+				return user, pass, nil
+			}
+			found = true
+		case "login":
+			if i+1 < len(words) {
+				i++
+				if found {
+					user = words[i]
+				}
+			}
+		case "password":
+			if i+1 < len(words) {
+				i++
+				if found {
+					pass = words[i]
+				}
+			}
+		}
+	}
+	if found {
+		return user, pass, nil
+	}
+	return "", "", fmt.Errorf("no proxy entry in %s", file)
+}
+
+// newAuthenticator sets up the scheme from the configuration
+func newAuthenticator(config *Config, user, pass string) (Authenticator, error) {
+	scheme := strings.ToLower(config.ProxyAuth)
+	if scheme == "" {
+		scheme = AuthBasic
+	}
+
+	switch scheme {
+	case AuthBasic:
+		if user == "" {
+			return nil, nil
+		}
+		return BasicAuth{User: user, Password: pass}, nil
+	case AuthNTLM, AuthNegotiate:
+		helper := config.ProxyHelper
+		if helper == "" {
+			helper = defaultHelpers[scheme]
+		}
+		cmd := strings.Fields(helper)
+		if user != "" {
+			cmd = append(cmd, "--username="+user)
+		}
+		return HelperAuth{Type: scheme, Command: cmd, Password: pass}, nil
+	}
+	return nil, fmt.Errorf("unknown proxy auth %s", config.ProxyAuth)
+}
@@ -0,0 +1,510 @@
+package cimbl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Blocklist formats
+const (
+	BlocklistText  = "text"
+	BlocklistSquid = "squid"
+	BlocklistEDL   = "edl"
+	BlocklistCPL   = "cpl"
+)
+
+// Verdicts against a blocklist, new entries having the URL as action like for a proxy
+const (
+	ActionListed    = "LISTED"
+	ActionCovered   = "COVERED"
+	ActionRedundant = "REDUNDANT"
+
+	// MethodBlocklist is the method of the verdicts from a blocklist
+	MethodBlocklist = "BLOCKLIST"
+)
+
+// DefaultBlocklist is the name of the blocklist in the verdicts
+const DefaultBlocklist = "blocklist"
+
+// BlocklistConfig is the current blocklist of the proxy, compared to instead of probing it
+//
+// [blocklist]
+// file = "/var/lib/erc-cimbl/bluecoat.cpl"
+// format = "cpl"
+type BlocklistConfig struct {
+	File string
+	// Format is text, squid, edl or cpl, guessed from the extension if empty
+	Format string
+	// Name is used in the verdicts, DefaultBlocklist if empty
+	Name string
+}
+
+// Blocklist is what a proxy already blocks
+type Blocklist struct {
+	Name string
+
+	// hosts are blocked as a whole, domains with their subdomains
+	hosts   map[string]bool
+	domains map[string]bool
+	// urls are host and path, without scheme
+	urls map[string]bool
+	nets []*net.IPNet
+}
+
+// NewBlocklist is an empty list
+func NewBlocklist(name string) *Blocklist {
+	if name == "" {
+		name = DefaultBlocklist
+	}
+	return &Blocklist{
+		Name:    name,
+		hosts:   map[string]bool{},
+		domains: map[string]bool{},
+		urls:    map[string]bool{},
+	}
+}
+
+// LoadBlocklist reads the file of the configuration
+func LoadBlocklist(bc BlocklistConfig) (*Blocklist, error) {
+	format := bc.Format
+	if format == "" {
+		format = guessBlocklist(bc.File)
+	}
+
+	fh, err := os.Open(bc.File)
+	if err != nil {
+		return nil, errors.Wrap(err, "blocklist")
+	}
+	defer fh.Close()
+
+	b := NewBlocklist(bc.Name)
+	if err := b.Read(fh, format); err != nil {
+		return nil, errors.Wrap(err, bc.File)
+	}
+	verbose("blocklist %s: %d entries", bc.File, b.Len())
+	return b, nil
+}
+
+// guessBlocklist returns the format from the extension, text by default
+func guessBlocklist(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".cpl":
+		return BlocklistCPL
+	case ".edl":
+		return BlocklistEDL
+	case ".acl", ".squid":
+		return BlocklistSquid
+	}
+	return BlocklistText
+}
+
+// blocklistParsers return the entries of one line per format
+var blocklistParsers = map[string]func(string) []string{
+	BlocklistText:  parseTextEntry,
+	BlocklistSquid: parseSquidEntry,
+	BlocklistEDL:   parseEDLEntry,
+	BlocklistCPL:   parseCPLEntry,
+}
+
+// Read adds the entries of r, lines it does not understand are ignored
+func (b *Blocklist) Read(r io.Reader, format string) error {
+	parse, ok := blocklistParsers[format]
+	if !ok {
+		return fmt.Errorf("unknown blocklist format %s", format)
+	}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		for _, e := range parse(line) {
+			if !b.add(e) {
+				debug("blocklist: skipping %s", e)
+			}
+		}
+	}
+	return s.Err()
+}
+
+// Entries are normalised by the parsers as "kind value"
+const (
+	entryHost   = "host"
+	entryDomain = "domain"
+	entryURL    = "url"
+	entryNet    = "net"
+)
+
+// add records one entry, false if it can not be understood
+func (b *Blocklist) add(e string) bool {
+	f := strings.SplitN(e, " ", 2)
+	if len(f) != 2 || f[1] == "" {
+		return false
+	}
+
+	switch f[0] {
+	case entryHost:
+		b.hosts[strings.ToLower(f[1])] = true
+	case entryDomain:
+		b.domains[strings.ToLower(f[1])] = true
+	case entryURL:
+		key, host, ok := urlKey(f[1])
+		if !ok {
+			return false
+		}
+		// Nothing after the host is the whole host
+		if key == host {
+			b.hosts[host] = true
+		} else {
+			b.urls[key] = true
+		}
+	case entryNet:
+		n := parseNet(f[1])
+		if n == nil {
+			return false
+		}
+		b.nets = append(b.nets, n)
+	default:
+		return false
+	}
+	return true
+}
+
+// Len is the number of entries
+func (b *Blocklist) Len() int {
+	return len(b.hosts) + len(b.domains) + len(b.urls) + len(b.nets)
+}
+
+// guessEntry handles what looks the same in all formats, URLs and addresses
+func guessEntry(str string) (string, bool) {
+	if strings.Contains(str, "://") {
+		return entryURL + " " + str, true
+	}
+	if parseNet(str) != nil {
+		return entryNet + " " + str, true
+	}
+	return "", false
+}
+
+// parseTextEntry: one URL, address, range or domain per line, a domain covering its subdomains
+func parseTextEntry(line string) []string {
+	str := strings.Fields(line)[0]
+	if e, ok := guessEntry(str); ok {
+		return []string{e}
+	}
+	if strings.Contains(str, "/") {
+		return []string{entryURL + " " + str}
+	}
+	return []string{entryDomain + " " + strings.TrimPrefix(strings.TrimPrefix(str, "*"), ".")}
+}
+
+// parseSquidEntry: dstdomain style, a leading dot for the subdomains
+func parseSquidEntry(line string) []string {
+	str := strings.Fields(line)[0]
+	if e, ok := guessEntry(str); ok {
+		return []string{e}
+	}
+	if strings.HasPrefix(str, ".") {
+		return []string{entryDomain + " " + str[1:]}
+	}
+	return []string{entryHost + " " + str}
+}
+
+// parseEDLEntry: Palo Alto lists, *. for the subdomains and no scheme for URLs
+func parseEDLEntry(line string) []string {
+	str := strings.Fields(line)[0]
+	if e, ok := guessEntry(str); ok {
+		return []string{e}
+	}
+	if strings.HasPrefix(str, "*.") {
+		return []string{entryDomain + " " + str[2:]}
+	}
+	if strings.Contains(str, "/") {
+		return []string{entryURL + " " + str}
+	}
+	return []string{entryHost + " " + str}
+}
+
+// cplConditions are the BlueCoat conditions we understand
+var cplConditions = map[string]string{
+	"url.domain=":         entryDomain,
+	"server_url.domain=":  entryDomain,
+	"url.host=":           entryHost,
+	"server_url.host=":    entryHost,
+	"url=":                entryURL,
+	"server_url=":         entryURL,
+	"url.address=":        entryNet,
+	"server_url.address=": entryNet,
+}
+
+// parseCPLEntry: BlueCoat policy, conditions anywhere on the line
+func parseCPLEntry(line string) []string {
+	all := []string{}
+	for _, tok := range strings.Fields(line) {
+		for cond, kind := range cplConditions {
+			if !strings.HasPrefix(strings.ToLower(tok), cond) {
+				continue
+			}
+			str := strings.Trim(tok[len(cond):], `"'`)
+			if kind == entryURL && !strings.Contains(str, "://") {
+				str = "http://" + str
+			}
+			all = append(all, kind+" "+str)
+		}
+	}
+	return all
+}
+
+// parseNet reads an address or a range
+func parseNet(str string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(str); err == nil {
+		return n
+	}
+	ip := net.ParseIP(str)
+	if ip == nil {
+		return nil
+	}
+	bits := 32
+	if ip.To4() == nil {
+		bits = 128
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// urlKey returns the host and path of an URL, scheme or not, and its host
+func urlKey(str string) (string, string, bool) {
+	if !strings.Contains(str, "://") {
+		str = "http://" + str
+	}
+	c, err := Canonicalize(str)
+	if err != nil {
+		return "", "", false
+	}
+	key := c.URL[strings.Index(c.URL, "://")+3:]
+	if i := strings.LastIndex(key, "@"); i >= 0 && i < strings.IndexAny(key+"/", "/") {
+		key = key[i+1:]
+	}
+	return strings.TrimSuffix(key, "/"), c.Host, true
+}
+
+// parents returns host and its parent domains, longest first, without the TLD
+func parents(host string) []string {
+	all := []string{host}
+	for {
+		i := strings.Index(host, ".")
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+		if !strings.Contains(host, ".") {
+			break
+		}
+		all = append(all, host)
+	}
+	return all
+}
+
+// coversHost returns the rule blocking the whole of host, empty if none
+func (b *Blocklist) coversHost(host string) string {
+	if b.hosts[host] {
+		return host
+	}
+	for _, d := range parents(host) {
+		if b.domains[d] {
+			return "." + d
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range b.nets {
+			if n.Contains(ip) {
+				return n.String()
+			}
+		}
+	}
+	return ""
+}
+
+// Verdict compares an URL, domain or network to the list
+func (b *Blocklist) Verdict(typ, value string) Verdict {
+	v := Verdict{Action: value, Method: MethodBlocklist}
+
+	switch typ {
+	case "url":
+		key, host, ok := urlKey(value)
+		if !ok {
+			return v
+		}
+		if b.urls[key] || (key == host && b.hosts[host]) {
+			v.Action, v.Rule = ActionListed, key
+			return v
+		}
+		// A shorter path
+		for p := key; strings.Contains(p, "/"); {
+			p = p[:strings.LastIndex(p, "/")]
+			if b.urls[p] {
+				v.Action, v.Rule = ActionCovered, p
+				return v
+			}
+		}
+		if rule := b.coversHost(host); rule != "" {
+			v.Action, v.Rule = ActionCovered, rule
+		}
+	case "domain":
+		if b.domains[value] {
+			v.Action, v.Rule = ActionListed, value
+			return v
+		}
+		// A host entry is not its subdomains
+		if rule := b.coversHost(value); rule != "" {
+			v.Action, v.Rule = ActionCovered, rule
+		}
+	case "network":
+		n := parseNet(value)
+		if n == nil {
+			return v
+		}
+		size, _ := n.Mask.Size()
+		for _, m := range b.nets {
+			msize, _ := m.Mask.Size()
+			if m.Contains(n.IP) && msize <= size {
+				v.Action, v.Rule = ActionCovered, m.String()
+				if m.String() == n.String() {
+					v.Action = ActionListed
+				}
+				return v
+			}
+		}
+	}
+	return v
+}
+
+// markRedundant drops the new entries a broader new one already covers, name being the blocklist
+func (r *Results) markRedundant(name string) {
+	redundant := func(e, rule string) {
+		v := Verdict{Action: ActionRedundant, Method: MethodBlocklist, Rule: rule}
+		if strings.Contains(e, "://") {
+			r.AddVerdict(e, name, v)
+		} else {
+			r.AddBlocklisted(e, name, v)
+		}
+	}
+	covering := func(hosts []string) string {
+		for _, d := range hosts {
+			if r.Domains[d] {
+				return d
+			}
+			if _, ok := r.Aggregated[d]; ok {
+				return d
+			}
+		}
+		return ""
+	}
+
+	for u := range r.URLs {
+		host, _ := hostDomain(u)
+		if d := covering(parents(host)); d != "" {
+			delete(r.URLs, u)
+			redundant(u, d)
+		}
+	}
+	for d := range r.Domains {
+		if p := covering(parents(d)[1:]); p != "" {
+			delete(r.Domains, d)
+			redundant(d, p)
+		}
+	}
+	for n := range r.Networks {
+		in := parseNet(n)
+		if in == nil {
+			continue
+		}
+		size, _ := in.Mask.Size()
+		for m := range r.Networks {
+			out := parseNet(m)
+			if m == n || out == nil {
+				continue
+			}
+			if osize, _ := out.Mask.Size(); osize < size && out.Contains(in.IP) {
+				delete(r.Networks, n)
+				redundant(n, m)
+				break
+			}
+		}
+	}
+}
+
+// Diff statuses
+const (
+	DiffNew       = "new"
+	DiffListed    = "listed"
+	DiffCovered   = "covered"
+	DiffRedundant = "redundant"
+)
+
+// DiffEntry is one indicator compared to the blocklist
+type DiffEntry struct {
+	Status string
+	Type   string
+	Value  string
+	// Rule is the entry covering it
+	Rule string
+}
+
+var diffOrder = map[string]int{DiffNew: 0, DiffRedundant: 1, DiffCovered: 2, DiffListed: 3}
+
+// Diff returns the verdicts of the blocklist called name, new ones first
+func (r *Results) Diff(name string) []DiffEntry {
+	all := []DiffEntry{}
+	add := func(typ, e string, vs map[string]Verdict) {
+		v, ok := vs[name]
+		if !ok || v.Method != MethodBlocklist {
+			return
+		}
+
+		d := DiffEntry{Type: typ, Value: e, Rule: v.Rule}
+		switch v.Action {
+		case e:
+			d.Status = DiffNew
+		case ActionListed:
+			d.Status = DiffListed
+		case ActionCovered:
+			d.Status = DiffCovered
+		case ActionRedundant:
+			d.Status = DiffRedundant
+		default:
+			return
+		}
+		all = append(all, d)
+	}
+	for u, vs := range r.Verdicts {
+		add("url", u, vs)
+	}
+	for e, vs := range r.Blocklisted {
+		if parseNet(e) != nil {
+			add("network", e, vs)
+		} else {
+			add("domain", e, vs)
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.Status != b.Status {
+			return diffOrder[a.Status] < diffOrder[b.Status]
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Value < b.Value
+	})
+	return all
+}
@@ -0,0 +1,164 @@
+package cimbl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuessBlocklist(t *testing.T) {
+	assert.Equal(t, BlocklistCPL, guessBlocklist("/etc/proxy/local.CPL"))
+	assert.Equal(t, BlocklistEDL, guessBlocklist("urls.edl"))
+	assert.Equal(t, BlocklistSquid, guessBlocklist("block.acl"))
+	assert.Equal(t, BlocklistText, guessBlocklist("block.txt"))
+	assert.Equal(t, BlocklistText, guessBlocklist("block"))
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	for _, f := range []string{"proxy.acl", "proxy.cpl", "proxy.edl", "proxy.txt"} {
+		b, err := LoadBlocklist(BlocklistConfig{File: "testdata/blocklist/" + f})
+		require.NoError(t, err, f)
+		assert.Equal(t, DefaultBlocklist, b.Name)
+		assert.NotZero(t, b.Len(), f)
+	}
+
+	_, err := LoadBlocklist(BlocklistConfig{File: "testdata/blocklist/none.txt"})
+	assert.Error(t, err)
+
+	_, err = LoadBlocklist(BlocklistConfig{File: "testdata/blocklist/proxy.txt", Format: "pac"})
+	assert.Error(t, err)
+}
+
+func TestBlocklist_Read(t *testing.T) {
+	tests := []struct {
+		format string
+		in     string
+		hosts  []string
+		doms   []string
+		urls   []string
+		nets   int
+	}{
+		{BlocklistText, "example.org\n*.example.net\nwww.example.com/a\n10.0.0.0/8 ; old\n", nil, []string{"example.net", "example.org"}, []string{"www.example.com/a"}, 1},
+		{BlocklistSquid, ".example.org\nwww.example.com\n192.0.2.1\n", []string{"www.example.com"}, []string{"example.org"}, nil, 1},
+		{BlocklistEDL, "*.example.org\nwww.example.com\nwww.example.com/a/\n", []string{"www.example.com"}, []string{"example.org"}, []string{"www.example.com/a"}, 0},
+		{BlocklistCPL, "define condition X\n url.domain=\"example.org\" url.host=www.example.com\n url=www.example.net/a\nend\n", []string{"www.example.com"}, []string{"example.org"}, []string{"www.example.net/a"}, 0},
+	}
+	for _, tt := range tests {
+		b := NewBlocklist("")
+		require.NoError(t, b.Read(strings.NewReader(tt.in), tt.format), tt.format)
+		assert.ElementsMatch(t, tt.hosts, keysOf(b.hosts), tt.format)
+		assert.ElementsMatch(t, tt.doms, keysOf(b.domains), tt.format)
+		assert.ElementsMatch(t, tt.urls, keysOf(b.urls), tt.format)
+		assert.Len(t, b.nets, tt.nets, tt.format)
+	}
+}
+
+func TestBlocklist_Verdict(t *testing.T) {
+	b := NewBlocklist("bluecoat")
+	require.NoError(t, b.Read(strings.NewReader(`.example.org
+www.example.com
+http://www.example.net/kit
+203.0.113.0/24
+`), BlocklistSquid))
+
+	tests := []struct {
+		typ, value string
+		action     string
+		rule       string
+	}{
+		{"url", "http://www.example.net/kit", ActionListed, "www.example.net/kit"},
+		{"url", "http://www.example.net/kit/drop.exe", ActionCovered, "www.example.net/kit"},
+		{"url", "http://www.example.net/other", "http://www.example.net/other", ""},
+		{"url", "http://www.example.com/", ActionListed, "www.example.com"},
+		{"url", "http://www.example.com/malware.php", ActionCovered, "www.example.com"},
+		{"url", "http://evil.example.org/x", ActionCovered, ".example.org"},
+		{"url", "http://203.0.113.5/x", ActionCovered, "203.0.113.0/24"},
+		{"domain", "example.org", ActionListed, "example.org"},
+		{"domain", "a.b.example.org", ActionCovered, ".example.org"},
+		{"domain", "example.com", "example.com", ""},
+		{"domain", "www.example.com", ActionCovered, "www.example.com"},
+		{"network", "203.0.113.0/24", ActionListed, "203.0.113.0/24"},
+		{"network", "203.0.113.128/25", ActionCovered, "203.0.113.0/24"},
+		{"network", "203.0.0.0/16", "203.0.0.0/16", ""},
+		{"network", "198.51.100.1", "198.51.100.1", ""},
+	}
+	for _, tt := range tests {
+		v := b.Verdict(tt.typ, tt.value)
+		assert.Equal(t, tt.action, v.Action, tt.value)
+		assert.Equal(t, tt.rule, v.Rule, tt.value)
+		assert.Equal(t, MethodBlocklist, v.Method)
+	}
+}
+
+func TestResults_MarkRedundant(t *testing.T) {
+	res := NewResults()
+	res.Add("url", "http://www.example.com/a")
+	res.Add("url", "http://www.example.net/a")
+	res.Add("domain", "example.com")
+	res.Add("domain", "evil.example.com")
+	res.Add("network", "10.0.0.0/8")
+	res.Add("network", "10.1.0.0/16")
+
+	res.markRedundant("bl")
+	assert.Equal(t, map[string]bool{"http://www.example.net/a": true}, res.URLs)
+	assert.Equal(t, map[string]bool{"example.com": true}, res.Domains)
+	assert.Equal(t, map[string]bool{"10.0.0.0/8": true}, res.Networks)
+	assert.Equal(t, Verdict{Action: ActionRedundant, Method: MethodBlocklist, Rule: "example.com"}, res.Verdicts["http://www.example.com/a"]["bl"])
+	assert.Equal(t, "10.0.0.0/8", res.Blocklisted["10.1.0.0/16"]["bl"].Rule)
+	assert.Equal(t, ActionRedundant, res.Blocklisted["evil.example.com"]["bl"].Action)
+	// Only URLs have verdicts
+	assert.Len(t, res.Verdicts, 1)
+}
+
+func TestCheckFiles_Blocklist(t *testing.T) {
+	ctx, err := NewContext(&Config{Blocklist: &BlocklistConfig{File: "testdata/blocklist/proxy.acl"}}, Options{Jobs: 1})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	assert.Equal(t, []string{DefaultBlocklist}, proxyNames(ctx))
+
+	res, err := CheckFiles(ctx, []string{"testdata/indicators.list"})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]bool{"http://10.1.1.1/": true, "http://10.1.1.2:8080/": true}, res.URLs)
+	assert.Equal(t, map[string]bool{"evil.example.net": true}, res.Domains)
+	assert.Empty(t, res.Networks)
+	assert.Empty(t, res.DNS)
+
+	want := []DiffEntry{
+		{DiffNew, "url", "http://10.1.1.1/", ""},
+		{DiffNew, "url", "http://10.1.1.2:8080/", ""},
+		{DiffNew, "url", "https://evil.example.net/login", ""},
+		{DiffCovered, "domain", "evil.example.org", ".example.org"},
+		{DiffCovered, "url", "http://www.example.com/malware.php", "www.example.com"},
+		{DiffCovered, "url", "http://www.example.com/other.php", "www.example.com"},
+		{DiffListed, "network", "203.0.113.0/24", "203.0.113.0/24"},
+	}
+	assert.Equal(t, want, res.Diff(DefaultBlocklist))
+	assert.Empty(t, res.Diff("other"))
+
+	// Domains and networks are not URL verdicts
+	assert.Len(t, res.Verdicts, 5)
+	assert.Equal(t, ActionCovered, res.Blocklisted["evil.example.org"][DefaultBlocklist].Action)
+	assert.Equal(t, ActionListed, res.Blocklisted["203.0.113.0/24"][DefaultBlocklist].Action)
+	urls, domains := res.PassedBy(DefaultBlocklist)
+	assert.Len(t, urls, 2)
+	assert.Equal(t, map[string]bool{"evil.example.net": true}, domains)
+	assert.Equal(t, map[string]int{VerdictBlock: 3, DiffCovered: 2}, NewSummary(ctx, res, "").Verdicts)
+}
+
+// proxyNames lists what the indicators are checked against
+func proxyNames(ctx *Context) []string {
+	names := []string{}
+	for _, p := range ctx.Proxies() {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestNewContext_BadBlocklist(t *testing.T) {
+	_, err := NewContext(&Config{Blocklist: &BlocklistConfig{File: "testdata/blocklist/none.acl"}}, Options{})
+	assert.Error(t, err)
+}
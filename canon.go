@@ -0,0 +1,293 @@
+package cimbl
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Canonical is the normalised form of an URL indicator
+type Canonical struct {
+	// URL is the normalised URL, without fragment nor default port
+	URL    string
+	Scheme string
+	// Host is lowercase, IDN as punycode, without trailing dot nor brackets for IPv6
+	Host string
+	// Port is only set if not the default one
+	Port string
+	// Domain is the registered domain (eTLD+1), Host for IP addresses
+	Domain string
+}
+
+var (
+	// hxxp://, hxxps[://], h**p:, ttp://, …
+	reFangScheme = regexp.MustCompile(`(?i)^(?:h(?:xx|\*\*|__)p|ttp)(s?)(?:\[:\]|\[://\]|:)(?://)?`)
+	// [.], (.), {.}, [dot], (dot), {dot}, with optional spaces around
+	reFangDot = regexp.MustCompile(`(?i)\s*(?:\[\.\]|\(\.\)|\{\.\}|\[dot\]|\(dot\)|\{dot\})\s*`)
+	// [:] and [/] are sometimes used as well
+	reFangColon = regexp.MustCompile(`\[:\]`)
+	reFangSlash = regexp.MustCompile(`\[/\]`)
+)
+
+// Refang undoes the usual ways indicators are made unclickable
+func Refang(str string) string {
+	str = strings.TrimSpace(str)
+	str = reFangScheme.ReplaceAllString(str, "http${1}://")
+	str = reFangDot.ReplaceAllString(str, ".")
+	str = reFangColon.ReplaceAllString(str, ":")
+	return reFangSlash.ReplaceAllString(str, "/")
+}
+
+// Defang makes an URL or a host unclickable: hxxp:// and [.] in the host part
+func Defang(str string) string {
+	scheme, rest := "", str
+	if i := strings.Index(str, "://"); i >= 0 {
+		scheme, rest = str[:i], str[i+3:]
+	}
+
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	host := strings.Replace(rest[:end], ".", "[.]", -1)
+
+	if scheme == "" {
+		return host + rest[end:]
+	}
+	if strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https") {
+		scheme = "hxxp" + scheme[4:]
+	}
+	return scheme + "://" + host + rest[end:]
+}
+
+// defaultPorts are removed from the canonical URL
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// cleanPath makes the path of rest (path and query) absolute without "." and ".." segments,
+// keeping the trailing slash (RFC 3986 §6.2.3)
+func cleanPath(rest string) string {
+	p, query := rest, ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		p, query = rest[:i], rest[i:]
+	}
+	if p == "" {
+		return "/" + query
+	}
+
+	clean := path.Clean(p)
+	if clean != "/" && (strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")) {
+		clean += "/"
+	}
+	return clean + query
+}
+
+// Canonicalize refangs and normalises str, a missing scheme means http
+func Canonicalize(str string) (*Canonical, error) {
+	s := Refang(str)
+
+	c := &Canonical{Scheme: "http"}
+	if i := strings.Index(s, "://"); i >= 0 {
+		if i > 0 {
+			c.Scheme = strings.ToLower(s[:i])
+		}
+		s = s[i+3:]
+	}
+	s = strings.TrimPrefix(s, "//")
+
+	switch c.Scheme {
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %s", c.Scheme)
+	}
+
+	// The fragment is never sent but must still be valid
+	if i := strings.Index(s, "#"); i >= 0 {
+		if _, err := normEscapes(s[i+1:]); err != nil {
+			return nil, err
+		}
+		s = s[:i]
+	}
+
+	end := strings.IndexAny(s, "/?")
+	if end < 0 {
+		end = len(s)
+	}
+	authority, rest := s[:end], s[end:]
+
+	// user:password@ is kept in the URL but is not part of the host
+	userinfo := ""
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userinfo, authority = authority[:i+1], authority[i+1:]
+	}
+
+	host, port, err := splitHostPort(authority)
+	if err != nil {
+		return nil, err
+	}
+	if port == defaultPorts[c.Scheme] {
+		port = ""
+	}
+	c.Port = port
+
+	if c.Host, err = canonHost(host); err != nil {
+		return nil, err
+	}
+
+	if rest, err = normEscapes(rest); err != nil {
+		return nil, err
+	}
+	rest = cleanPath(rest)
+
+	hostport := c.Host
+	if strings.Contains(hostport, ":") {
+		hostport = "[" + hostport + "]"
+	}
+	if c.Port != "" {
+		hostport += ":" + c.Port
+	}
+	c.URL = c.Scheme + "://" + userinfo + hostport + rest
+
+	c.Domain = c.Host
+	if net.ParseIP(c.Host) == nil {
+		if d, err := publicsuffix.EffectiveTLDPlusOne(c.Host); err == nil {
+			c.Domain = d
+		}
+	}
+	return c, nil
+}
+
+// canonURL is the canonical form of str, or str refanged if it can not be parsed
+func canonURL(str string) string {
+	c, err := Canonicalize(str)
+	if err != nil {
+		debug("canon %s: %v", str, err)
+		return Refang(str)
+	}
+	return c.URL
+}
+
+// splitHostPort handles [IPv6]:port, [IPv4] and host:port
+func splitHostPort(authority string) (string, string, error) {
+	host, port := authority, ""
+
+	if strings.HasPrefix(authority, "[") {
+		i := strings.Index(authority, "]")
+		if i < 0 {
+			return "", "", ErrParseError
+		}
+		host, port = authority[1:i], strings.TrimPrefix(authority[i+1:], ":")
+		if net.ParseIP(host) == nil {
+			return "", "", ErrParseError
+		}
+	} else if i := strings.LastIndex(authority, ":"); i >= 0 {
+		host, port = authority[:i], authority[i+1:]
+	}
+
+	for _, r := range port {
+		if r < '0' || r > '9' {
+			return "", "", ErrParseError
+		}
+	}
+	return host, port, nil
+}
+
+// canonHost lowercases and removes the trailing dot, IDN are converted to punycode with the
+// lookup mapping of IDNA
+func canonHost(host string) (string, error) {
+	host, err := url.PathUnescape(host)
+	if err != nil {
+		return "", ErrParseError
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", ErrParseError
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ascii := true
+	for _, l := range strings.Split(host, ".") {
+		if l == "" {
+			return "", ErrParseError
+		}
+		for _, r := range l {
+			switch {
+			case r >= utf8.RuneSelf:
+				ascii = false
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			default:
+				return "", ErrParseError
+			}
+		}
+	}
+	if ascii {
+		return host, nil
+	}
+
+	// Mapped like the proxies and resolvers do, ß is ss
+	idn, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", ErrParseError
+	}
+	return idn, nil
+}
+
+// normEscapes uppercases the escapes, decodes the unreserved characters and escapes
+// the ones that should be.
+func normEscapes(s string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%':
+			if i+2 >= len(s) || !ishex(s[i+1]) || !ishex(s[i+2]) {
+				return "", ErrParseError
+			}
+			v := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if unreserved(v) {
+				b.WriteByte(v)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", v)
+			}
+			i += 2
+		case c <= ' ' || c >= utf8.RuneSelf || c == '"' || c == '<' || c == '>' || c == '\\':
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func ishex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// unreserved is RFC 3986 section 2.3
+func unreserved(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+
+	"github.com/keltia/erc-cimbl"
+	"github.com/pkg/errors"
+)
+
+// command is one of our subcommands, flags registering its options
+type command struct {
+	name  string
+	help  string
+	flags func(fs *flag.FlagSet)
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"parse", "Display the indicators of the files", inputFlags, cmdParse},
+	{"check", "Check the files and save the results", checkFlags, cmdCheck},
+	{"diff", "Compare the files to the blocklist, nothing is sent to the proxies", checkFlags, cmdDiff},
+	{"report", "Display the report from saved results (last one by default)", savedFlags, cmdReport},
+	{"send", "Mail the report from saved results (last one by default)", savedFlags, cmdSend},
+	{"ticket", "Open a ticket from saved results (last one by default)", savedFlags, cmdTicket},
+	{"patch", "Commit saved results to the blocklist repository (last one by default)", savedFlags, cmdPatch},
+	{"history", "List the saved results", func(*flag.FlagSet) {}, cmdHistory},
+	{"template", "Render the report templates against sample or saved results", templateFlags, cmdTemplate},
+}
+
+// findCommand returns the command called name, nil if there is none
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runCommand parses the options of c then runs it
+func runCommand(c *command, args []string) error {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [options] args…\n\n%s\n\n", cimbl.MyName, c.name, c.help)
+		fs.PrintDefaults()
+	}
+
+	// Registering resets the variables, keep the options given before the command
+	given := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if v := f.Value.String(); v != f.DefValue {
+			given[f.Name] = v
+		}
+	})
+	commonFlags(fs)
+	c.flags(fs)
+	for name, v := range given {
+		if fs.Lookup(name) != nil {
+			fs.Set(name, v)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(err, c.name)
+	}
+	return c.run(fs.Args())
+}
+
+// store is where the results are saved, "results" in our directory by default
+func store(config *cimbl.Config) *cimbl.Store {
+	if config.Store != "" {
+		return cimbl.NewStore(config.Store)
+	}
+	return cimbl.NewStore(filepath.Join(baseDir, "results"))
+}
+
+// cmdParse reads the files and displays their indicators
+func cmdParse(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("parse: no files")
+	}
+
+	ctx, err := setup()
+	if err != nil {
+		return errors.Wrap(err, "parse")
+	}
+	defer ctx.Cleanup()
+
+	l, err := cimbl.LoadList(args, ctx.Options())
+	if err != nil {
+		if fStrict {
+			return errors.Wrap(err, "strict")
+		}
+		if _, ok := err.(*cimbl.ListError); !ok {
+			return errors.Wrap(err, "parse")
+		}
+	}
+	dump(os.Stdout, l.Results())
+
+	if err != nil {
+		return &PartialError{Inputs: err}
+	}
+	return nil
+}
+
+// dump displays one indicator per line, sorted by type then value
+func dump(w io.Writer, res *cimbl.Results) {
+	sections := []struct {
+		name string
+		all  map[string]bool
+	}{
+		{"url", res.URLs},
+		{"domain", res.Domains},
+		{"network", res.Networks},
+		{"filename", res.Paths},
+		{"hash", res.Hashes},
+	}
+
+	for _, s := range sections {
+		keys := []string{}
+		for k := range s.all {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%s\n", s.name, k)
+		}
+	}
+}
+
+// cmdCheck checks the files and saves the results for report and send
+func cmdCheck(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("check: no files")
+	}
+
+	ctx, err := setup()
+	if err != nil {
+		return errors.Wrap(err, "check")
+	}
+	if fProfile {
+		defer pprof.StopCPUProfile()
+	}
+	defer ctx.Cleanup()
+
+	res, err := checkAll(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	name := fOutput
+	if name != "" {
+		err = cimbl.SaveResults(name, res)
+	} else {
+		name, err = store(ctx.Config()).Save(res)
+	}
+	if err != nil {
+		return errors.Wrap(err, "check")
+	}
+	fmt.Printf("%s: %s\n", name, summary(res))
+	// Failures are logged, the results are saved anyway.  Links only go to the store.
+	if fOutput != "" {
+		name = ""
+	}
+	cimbl.Notify(ctx, res, name)
+
+	return finish(ctx, res)
+}
+
+// cmdDiff lists what is new, listed, covered or redundant against the blocklist
+func cmdDiff(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("diff: no files")
+	}
+
+	ctx, err := setup()
+	if err != nil {
+		return errors.Wrap(err, "diff")
+	}
+	defer ctx.Cleanup()
+
+	b := ctx.Blocklist()
+	if b == nil {
+		return fmt.Errorf("diff: no blocklist, use -blocklist or configure one")
+	}
+
+	res, err := checkAll(ctx, args)
+	if err != nil {
+		return err
+	}
+	if fOutput != "" {
+		if err := cimbl.SaveResults(fOutput, res); err != nil {
+			return errors.Wrap(err, "diff")
+		}
+	}
+
+	for _, d := range res.Diff(b.Name) {
+		fmt.Printf("%s\t%s\t%s\t%s\n", d.Status, d.Type, d.Value, d.Rule)
+	}
+	return nil
+}
+
+// summary counts what is to be blocked
+func summary(res *cimbl.Results) string {
+	return fmt.Sprintf("%d URLs, %d domains, %d aggregated, %d networks, %d filenames, %d hashes, %d unchecked",
+		len(res.URLs), len(res.Domains), len(res.Aggregated), len(res.Networks), len(res.Paths),
+		len(res.Hashes), len(res.Unchecked))
+}
+
+// load reads the results from -i or the store, the one named in args or the last one
+func load(config *cimbl.Config, args []string) (*cimbl.Saved, error) {
+	if fSaved != "" {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("-i and a saved result are exclusive")
+		}
+		saved, err := cimbl.LoadResults(fSaved)
+		if err == nil {
+			saved.Name = fSaved
+		}
+		return saved, err
+	}
+
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	return store(config).Load(name)
+}
+
+// report loads the saved results and displays or sends them
+func report(args []string, mail bool) error {
+	if len(args) > 1 {
+		return fmt.Errorf("only one saved result at a time")
+	}
+
+	fDoMail = mail
+	ctx, err := setup()
+	if err != nil {
+		return err
+	}
+	defer ctx.Cleanup()
+
+	if mail && !ctx.Options().Mail {
+		return fmt.Errorf("no mail server configured")
+	}
+
+	saved, err := load(ctx.Config(), args)
+	if err != nil {
+		return err
+	}
+	verbose("using %s, from %s on %v", saved.Name, saved.Results.Meta.Program, saved.Results.Meta.Started)
+
+	return cimbl.SendReport(ctx, saved.Results)
+}
+
+// cmdReport displays the mail for saved results
+func cmdReport(args []string) error {
+	return errors.Wrap(report(args, false), "report")
+}
+
+// cmdSend mails the report for saved results
+func cmdSend(args []string) error {
+	return errors.Wrap(report(args, true), "send")
+}
+
+// ticket opens the change request and tells which one
+func ticket(ctx *cimbl.Context, res *cimbl.Results) error {
+	key, id, err := cimbl.OpenTicket(ctx, res)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		log.Print("Nothing to block, no ticket")
+		return nil
+	}
+	if id == "" {
+		id = "opened"
+	}
+	fmt.Printf("%s: %s\n", key, id)
+	return nil
+}
+
+// cmdTicket opens a ticket for saved results
+func cmdTicket(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("ticket: only one saved result at a time")
+	}
+
+	ctx, err := setup()
+	if err != nil {
+		return errors.Wrap(err, "ticket")
+	}
+	defer ctx.Cleanup()
+
+	saved, err := load(ctx.Config(), args)
+	if err != nil {
+		return errors.Wrap(err, "ticket")
+	}
+	verbose("using %s", saved.Name)
+
+	return errors.Wrap(ticket(ctx, saved.Results), "ticket")
+}
+
+// patch commits the new entries and tells which ones
+func patch(ctx *cimbl.Context, res *cimbl.Results) error {
+	p, err := cimbl.WritePatch(ctx, res)
+	if err != nil {
+		return err
+	}
+	for _, line := range p.Added {
+		fmt.Printf("+%s\n", line)
+	}
+	if len(p.Unwritable) != 0 {
+		log.Printf("Not in the blocklist format, to be added by hand:\n%s", strings.Join(p.Unwritable, "\n"))
+	}
+	if p.Commit == "" {
+		fmt.Printf("Nothing new, %d already blocked\n", len(p.Skipped))
+		return nil
+	}
+	fmt.Printf("%s: %d added, %d already blocked, not pushed\n", p.Commit, len(p.Added), len(p.Skipped))
+	return nil
+}
+
+// cmdPatch commits saved results to the blocklist repository
+func cmdPatch(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("patch: only one saved result at a time")
+	}
+
+	ctx, err := setup()
+	if err != nil {
+		return errors.Wrap(err, "patch")
+	}
+	defer ctx.Cleanup()
+
+	saved, err := load(ctx.Config(), args)
+	if err != nil {
+		return errors.Wrap(err, "patch")
+	}
+	verbose("using %s", saved.Name)
+
+	return patch(ctx, saved.Results)
+}
+
+// cmdHistory lists the saved results
+func cmdHistory(args []string) error {
+	config, err := loadConfig()
+	if err != nil {
+		verbose("no config file")
+	}
+
+	s := store(config)
+	names, err := s.Names()
+	if err != nil {
+		return errors.Wrap(err, "history")
+	}
+
+	for _, name := range names {
+		saved, err := s.Load(name)
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", name, strings.Join(saved.Files, ","), summary(saved.Results))
+	}
+	return nil
+}
+
+// cmdTemplate displays the report with the templates to check them, nothing is sent
+func cmdTemplate(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("template: only one saved result at a time")
+	}
+
+	ctx, err := setup()
+	if err != nil {
+		return errors.Wrap(err, "template")
+	}
+	defer ctx.Cleanup()
+
+	res := cimbl.SampleResults()
+	if fSaved != "" || len(args) == 1 {
+		saved, err := load(ctx.Config(), args)
+		if err != nil {
+			return errors.Wrap(err, "template")
+		}
+		res = saved.Results
+	}
+
+	all, err := ctx.Reports(res)
+	if err != nil {
+		return errors.Wrap(err, "template")
+	}
+	for _, txt := range all {
+		fmt.Println(txt)
+	}
+	return nil
+}
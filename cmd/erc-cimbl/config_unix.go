@@ -5,12 +5,14 @@ package main
 import (
 	"os"
 	"path/filepath"
+
+	"github.com/keltia/erc-cimbl"
 )
 
 var (
 	baseDir = filepath.Join(os.Getenv("HOME"),
 		".config",
-		MyName,
+		cimbl.MyName,
 	)
 
 	configName = "config.toml"
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/keltia/archive"
+	"github.com/keltia/erc-cimbl"
+	"github.com/keltia/sandbox"
+	"github.com/pkg/errors"
+)
+
+var (
+	fNoCleanup bool
+	fDebug     bool
+	fDoMail    bool
+	fVerbose   bool
+	fNoURLs    bool
+	fNoPaths   bool
+	fProfile   bool
+	fSkipped   bool
+	fTicket    bool
+	fPatch     bool
+	fJobs      int
+
+	fRate     float64
+	fPerHost  int
+	fRetries  int
+	fHTTPTime time.Duration
+	fTimeout  time.Duration
+	fRPZ      string
+	fDefang   bool
+	fOrder    string
+	fAggr     int
+	fInput    string
+	fStrict   bool
+	fOutput   string
+	fSaved    string
+	fTmplText string
+	fTmplHTML string
+	fBlock    string
+	fBlockFmt string
+
+	skipped = []string{}
+)
+
+// interruptible sets up the deadline and SIGINT handling for the run
+func interruptible(timeout time.Duration) (context.Context, context.CancelFunc) {
+	run, cancel := context.WithCancel(context.Background())
+	if timeout != 0 {
+		// Both must be released, the deadline one is derived from the other
+		var stop context.CancelFunc
+		run, stop = context.WithTimeout(run, timeout)
+		all := cancel
+		cancel = func() {
+			stop()
+			all()
+		}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+
+	go func() {
+		defer signal.Stop(sigs)
+
+		select {
+		case <-sigs:
+			log.Printf("Interrupted, finishing with a partial report…")
+			cancel()
+		case <-run.Done():
+		}
+	}()
+	return run, cancel
+}
+
+// Exit codes
+const (
+	ExitOK      = 0
+	ExitError   = 1
+	ExitPartial = 2
+)
+
+// PartialError means the report was done but some inputs or URLs were left out
+type PartialError struct {
+	Inputs    error
+	Unchecked int
+}
+
+func (e *PartialError) Error() string {
+	if e.Inputs == nil {
+		return fmt.Sprintf("partial results, %d URLs unchecked", e.Unchecked)
+	}
+	return fmt.Sprintf("partial results, %d URLs unchecked: %v", e.Unchecked, e.Inputs)
+}
+
+// exitCode maps the result of realmain
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if _, ok := errors.Cause(err).(*PartialError); ok {
+		return ExitPartial
+	}
+	return ExitError
+}
+
+// Usage string override.
+var Usage = func() {
+	fmt.Fprintf(os.Stderr, "%s/%s (Archive/%s Sandbox/%s)\n\n",
+		cimbl.MyName, cimbl.MyVersion, archive.Version(), sandbox.Version())
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [command [options]] files…\n\nCommands:\n", cimbl.MyName)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nWithout a command, files are checked and the report sent.\n\n")
+
+	flag.PrintDefaults()
+}
+
+// commonFlags are for every command
+func commonFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&fDebug, "D", false, "Debug mode")
+	fs.BoolVar(&fVerbose, "v", false, "Verbose mode")
+}
+
+// inputFlags are for reading the files
+func inputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&fInput, "t", cimbl.InputAuto, "Input type: auto, cimbl, ip, list or json (- is stdin)")
+	fs.BoolVar(&fStrict, "strict", false, "Abort on any bad input")
+}
+
+// checkFlags are for checking the indicators
+func checkFlags(fs *flag.FlagSet) {
+	inputFlags(fs)
+
+	fs.BoolVar(&fNoCleanup, "C", false, "No cleanup for temp files.")
+	fs.BoolVar(&fNoPaths, "P", false, "Do not check filenames")
+	fs.BoolVar(&fNoURLs, "U", false, "Do not check URLs")
+	fs.IntVar(&fJobs, "j", runtime.NumCPU(), "parallel jobs")
+	fs.BoolVar(&fProfile, "prof", false, "Profiling")
+	fs.Float64Var(&fRate, "rate", 0, "Max requests per second (0 is no limit)")
+	fs.IntVar(&fPerHost, "per-host", 0, "Max parallel requests per host (0 is no limit)")
+	fs.IntVar(&fRetries, "retries", 0, "Retries on timeouts/gateway errors")
+	fs.DurationVar(&fHTTPTime, "http-timeout", 0, "Timeout for each request (default 10s)")
+	fs.DurationVar(&fTimeout, "timeout", 0, "Overall deadline for the checks (0 is none)")
+	fs.StringVar(&fRPZ, "rpz", "", "Write a RPZ zone file")
+	fs.IntVar(&fAggr, "aggregate", 0, "Block the domain from that many URLs (0 is never)")
+	fs.StringVar(&fOutput, "o", "", "Save the results into this file")
+	fs.StringVar(&fBlock, "blocklist", "", "Compare to this blocklist instead of probing the proxies")
+	fs.StringVar(&fBlockFmt, "blocklist-format", "", "Blocklist format: text, squid, edl or cpl (default from extension)")
+}
+
+// reportFlags are for rendering the mail
+func reportFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&fDefang, "defang", false, "Defang URLs and domains in the mail")
+	fs.StringVar(&fOrder, "order", "", "Order of the entries in the mail: host, domain or threat")
+}
+
+// savedFlags are for reporting saved results
+func savedFlags(fs *flag.FlagSet) {
+	reportFlags(fs)
+	fs.StringVar(&fSaved, "i", "", "Read the results from this file instead of the store")
+}
+
+// templateFlags are for trying report templates
+func templateFlags(fs *flag.FlagSet) {
+	savedFlags(fs)
+	fs.StringVar(&fTmplText, "text", "", "Text template instead of the configured one")
+	fs.StringVar(&fTmplHTML, "html", "", "HTML template instead of the configured one")
+}
+
+func init() {
+	flag.Usage = Usage
+
+	commonFlags(flag.CommandLine)
+	checkFlags(flag.CommandLine)
+	reportFlags(flag.CommandLine)
+
+	flag.BoolVar(&fDoMail, "M", false, "Send mail")
+	flag.BoolVar(&fSkipped, "S", false, "Display skipped URLs")
+	flag.BoolVar(&fTicket, "T", false, "Open a ticket too")
+	flag.BoolVar(&fPatch, "G", false, "Commit the new entries to the blocklist repository too")
+}
+
+// loadConfig reads our configuration file
+func loadConfig() (*cimbl.Config, error) {
+	return cimbl.LoadConfig(filepath.Join(baseDir, configName))
+}
+
+func setup() (*cimbl.Context, error) {
+	if fDebug {
+		fVerbose = true
+	}
+	cimbl.SetVerbose(fVerbose)
+	cimbl.SetDebug(fDebug)
+
+	verbose("%s/%s Archive/%s Sandbox/%s",
+		cimbl.MyName, cimbl.MyVersion, archive.Version(), sandbox.Version())
+
+	// No config file is not an error but you do not get to send mail
+	config, err := loadConfig()
+	if err != nil {
+		verbose("no config file, mail is disabled.")
+		fDoMail = false
+	}
+
+	if fProfile {
+		f, _ := os.Create("cpu.prof")
+		if err = pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("cant profile")
+		}
+	}
+	// Flags override the configuration file
+	if fRate != 0 {
+		config.Rate = fRate
+	}
+	if fPerHost != 0 {
+		config.PerHost = fPerHost
+	}
+	if fRetries != 0 {
+		config.Retries = fRetries
+	}
+	if fHTTPTime != 0 {
+		config.Timeout.Duration = fHTTPTime
+	}
+	if fAggr != 0 {
+		config.Aggregate = fAggr
+	}
+	if fRPZ != "" {
+		if config.RPZ == nil {
+			config.RPZ = &cimbl.RPZConfig{}
+		}
+		config.RPZ.File = fRPZ
+	}
+	if fBlock != "" {
+		config.Blocklist = &cimbl.BlocklistConfig{File: fBlock, Format: fBlockFmt}
+	}
+	if fTmplText != "" || fTmplHTML != "" {
+		if config.Template == nil {
+			config.Template = &cimbl.TemplateConfig{}
+		}
+		if fTmplText != "" {
+			config.Template.Text = fTmplText
+		}
+		if fTmplHTML != "" {
+			config.Template.HTML = fTmplHTML
+		}
+	}
+
+	opts := cimbl.Options{
+		Jobs:    fJobs,
+		Input:   fInput,
+		Strict:  fStrict,
+		NoURLs:  fNoURLs,
+		NoPaths: fNoPaths,
+		Mail:    fDoMail,
+		Defang:  fDefang,
+		Order:   fOrder,
+	}
+	return cimbl.NewContext(config, opts)
+}
+
+// checkAll checks the files then applies the aggregation and the allowlist
+func checkAll(ctx *cimbl.Context, args []string) (*cimbl.Results, error) {
+	run, cancel := interruptible(fTimeout)
+	defer cancel()
+	ctx.SetRun(run)
+
+	res, err := cimbl.CheckFiles(ctx, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "error processing files")
+	}
+
+	config := ctx.Config()
+	if _, err := res.Aggregate(config.Aggregate, config.AggregateBy); err != nil {
+		return nil, errors.Wrap(err, "aggregate")
+	}
+
+	// After the aggregation so that no domain is blocked over what we allow
+	res.Allow(ctx.Allowlist())
+	if len(res.Allowed) != 0 {
+		log.Printf("%d entries protected by the allowlist", len(res.Allowed))
+	}
+
+	if n := res.Interrupted(); n != 0 {
+		log.Printf("Partial results, %d URLs unchecked: %v", n, run.Err())
+	}
+
+	verbose("res=%v", res)
+	return res, nil
+}
+
+// finish exports the RPZ zone, removes the files and tells whether the results are partial
+func finish(ctx *cimbl.Context, res *cimbl.Results) error {
+	if config := ctx.Config(); config.RPZ != nil {
+		if err := cimbl.ExportRPZ(*config.RPZ, res); err != nil {
+			return errors.Wrap(err, "rpz")
+		}
+	}
+
+	if !fNoCleanup {
+		for _, fn := range res.Files() {
+			if err := os.Remove(fn); err != nil {
+				log.Printf("Can not delete %s: %v", fn, err)
+			}
+		}
+	}
+
+	if res.Failed() != nil || res.Interrupted() != 0 {
+		return &PartialError{Inputs: res.Failed(), Unchecked: res.Interrupted()}
+	}
+	return nil
+}
+
+func realmain(args []string) error {
+	if len(args) != 0 {
+		if c := findCommand(args[0]); c != nil {
+			return runCommand(c, args[1:])
+		}
+	}
+
+	ctx, err := setup()
+	if err != nil {
+		return errors.Wrap(err, "realmain")
+	}
+	if fProfile {
+		defer pprof.StopCPUProfile()
+	}
+	defer ctx.Cleanup()
+
+	if (fNoURLs && fNoPaths) || len(args) == 0 {
+		log.Println("Nothing to do!")
+		return nil
+	}
+
+	res, err := checkAll(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	// Saved first so that the report can be sent again without checking everything
+	name := fOutput
+	if name != "" {
+		err = cimbl.SaveResults(name, res)
+	} else {
+		name, err = store(ctx.Config()).Save(res)
+	}
+	if err != nil {
+		return errors.Wrap(err, "saving results")
+	}
+	verbose("results saved as %s", name)
+
+	// Do something with the results
+	if err := cimbl.SendReport(ctx, res); err != nil {
+		if fOutput == "" {
+			log.Printf("Results kept as %s, use \"send %s\" to retry", name, name)
+		}
+		return errors.Wrap(err, "sending mail")
+	}
+
+	if fTicket && !res.Empty() {
+		if err := ticket(ctx, res); err != nil {
+			return err
+		}
+	}
+
+	if fPatch {
+		if err := patch(ctx, res); err != nil {
+			return err
+		}
+	}
+
+	// Failures to notify are only logged, links only go to the store
+	if fOutput != "" {
+		name = ""
+	}
+	cimbl.Notify(ctx, res, name)
+
+	if fSkipped {
+		if len(skipped) != 0 {
+			log.Printf("\nSkipped URLs:\n%s", strings.Join(skipped, "\n"))
+		}
+	}
+
+	return finish(ctx, res)
+}
+
+// verbose displays only if fVerbose is set
+func verbose(str string, a ...interface{}) {
+	if fVerbose {
+		log.Printf(str, a...)
+	}
+}
+
+func main() {
+	// Parse CLI
+	flag.Parse()
+
+	if err := realmain(flag.Args()); err != nil {
+		log.Printf("Error %v\n", err)
+		os.Exit(exitCode(err))
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keltia/erc-cimbl"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	// realmain saves every run in the store of baseDir
+	os.RemoveAll("../../testdata/results")
+	os.Exit(code)
+}
+
+func TestSetup(t *testing.T) {
+	baseDir = "../../testdata"
+
+	ctx, err := setup()
+	assert.NotNil(t, ctx)
+	assert.NoError(t, err)
+
+	assert.NotNil(t, ctx.Config())
+}
+
+func TestSetupNone(t *testing.T) {
+	baseDir = "testx"
+
+	ctx, err := setup()
+	assert.NotNil(t, ctx)
+	assert.NoError(t, err)
+
+	assert.Empty(t, ctx.Config())
+}
+
+func TestSetupNoneDebug(t *testing.T) {
+	baseDir = "testx"
+
+	fDebug = true
+	ctx, err := setup()
+	assert.NotNil(t, ctx)
+	assert.NoError(t, err)
+
+	assert.Empty(t, ctx.Config())
+	assert.True(t, fVerbose)
+
+	fDebug = false
+}
+
+func TestSetupNoneDebugSandboxInvalid(t *testing.T) {
+	baseDir = "../../testdata"
+
+	prev := os.Getenv("TMPDIR")
+	if prev == "" {
+		prev = "/tmp"
+	}
+	require.NoError(t, os.Setenv("TMPDIR", "/nonexistent"))
+
+	fDebug = true
+	ctx, err := setup()
+	assert.Nil(t, ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, os.Setenv("TMPDIR", prev))
+
+	fDebug = false
+}
+
+func setvars(t *testing.T) {
+	// Insert our values
+	require.NoError(t, os.Setenv("HTTP_PROXY", "http://proxy:8080/"))
+	require.NoError(t, os.Setenv("HTTPS_PROXY", "http://proxy:8080/"))
+	require.NoError(t, os.Setenv("http_proxy", "http://proxy:8080/"))
+	require.NoError(t, os.Setenv("https_proxy", "http://proxy:8080/"))
+}
+
+func unsetvars(t *testing.T) {
+	// Remove our values
+	require.NoError(t, os.Unsetenv("HTTP_PROXY"))
+	require.NoError(t, os.Unsetenv("HTTPS_PROXY"))
+	require.NoError(t, os.Unsetenv("http_proxy"))
+	require.NoError(t, os.Unsetenv("https_proxy"))
+}
+
+func TestSetupProxyError(t *testing.T) {
+	setvars(t)
+
+	baseDir = "../../testdata"
+	netrc := filepath.Join("..", "..", "testdata", "test-netrc")
+	require.NoError(t, os.Chmod(netrc, 0600))
+	require.NoError(t, os.Setenv("NETRC", netrc))
+
+	ctx, err := setup()
+	assert.NotNil(t, ctx)
+	assert.NoError(t, err)
+
+	assert.NotNil(t, ctx.Config())
+	unsetvars(t)
+}
+
+func TestSetupProxyAuth(t *testing.T) {
+	setvars(t)
+
+	baseDir = "../../testdata"
+	netrc := filepath.Join("..", "..", "testdata", "test-netrc")
+	require.NoError(t, os.Chmod(netrc, 0600))
+	require.NoError(t, os.Setenv("NETRC", netrc))
+
+	ctx, err := setup()
+	assert.NotNil(t, ctx)
+	assert.NoError(t, err)
+
+	assert.NotNil(t, ctx.Config())
+	unsetvars(t)
+}
+
+func TestSetupServer(t *testing.T) {
+	baseDir = "../../testdata"
+	configName = "config-smtp.toml"
+	require.NoError(t, os.Setenv("NETRC", filepath.Join(".", "test", "test-netrc")))
+
+	fDebug = true
+	ctx, err := setup()
+	assert.NotNil(t, ctx)
+	assert.NoError(t, err)
+
+	assert.NotNil(t, ctx.Config())
+	assert.NotEmpty(t, ctx.Config().Server)
+
+	require.NoError(t, os.Unsetenv("NETRC"))
+	fDebug = false
+}
+
+func TestRealMain_Noarg(t *testing.T) {
+	err := realmain([]string{})
+	assert.NoError(t, err)
+}
+
+func TestRealMain_InvalidFile(t *testing.T) {
+	err := realmain([]string{"../../testdata/bad.csv"})
+	assert.IsType(t, &PartialError{}, err)
+	assert.Equal(t, ExitPartial, exitCode(err))
+}
+
+func TestRealMain_Badtemp(t *testing.T) {
+	old := os.Getenv("TMPDIR")
+	require.NoError(t, os.Setenv("TMPDIR", "/nonexistent"))
+
+	err := realmain([]string{})
+	assert.Error(t, err)
+
+	require.NoError(t, os.Setenv("TMPDIR", old))
+}
+
+func TestRealMain_Onebadarg(t *testing.T) {
+	err := realmain([]string{"/foo.bar"})
+	assert.IsType(t, &PartialError{}, err)
+	assert.Equal(t, ExitPartial, exitCode(err))
+}
+
+func TestRealMain_Strict(t *testing.T) {
+	fStrict = true
+	err := realmain([]string{"../../testdata/CIMBL-0667-CERTS.csv", "/foo.bar"})
+	assert.Error(t, err)
+	assert.Equal(t, ExitError, exitCode(err))
+	fStrict = false
+}
+
+func TestRealMain_Onearg_Empty(t *testing.T) {
+	err := realmain([]string{"../../testdata/CIMBL-0667-CERTS.csv"})
+	assert.NoError(t, err)
+}
+
+func TestRealMain_Onearg_EmptySkipped(t *testing.T) {
+	fSkipped = true
+	err := realmain([]string{"../../testdata/CIMBL-0667-CERTS.csv"})
+	assert.NoError(t, err)
+	fSkipped = false
+}
+
+func TestRealMain_Onearg_EmptySkipped2(t *testing.T) {
+	fSkipped = true
+	skipped = append(skipped, "https://example.net/")
+	err := realmain([]string{"../../testdata/CIMBL-0667-CERTS.csv"})
+	assert.NoError(t, err)
+	fSkipped = false
+	skipped = []string{}
+}
+
+func TestRealMain_Onearg_Good(t *testing.T) {
+	err := realmain([]string{"../../testdata/CIMBL-0666-CERTS.csv"})
+	assert.NoError(t, err)
+}
+
+func TestRealMain_SavedInStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cimbl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	baseDir = dir
+	require.NoError(t, realmain([]string{"../../testdata/CIMBL-0666-CERTS.csv"}))
+
+	// Still there once the sandbox is gone
+	names, err := cimbl.NewStore(filepath.Join(dir, "results")).Names()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+	baseDir = "../../testdata"
+}
+
+func TestRealMain_Onearg_GoodZip(t *testing.T) {
+	err := realmain([]string{"../../testdata/CIMBL-0666-CERTS.zip"})
+	assert.NoError(t, err)
+}
+
+func TestUsage(t *testing.T) {
+	Usage()
+}
+
+func TestMain1(t *testing.T) {
+	main()
+}
+
+func TestInterruptible(t *testing.T) {
+	run, cancel := interruptible(10 * time.Millisecond)
+	defer cancel()
+
+	<-run.Done()
+	assert.Equal(t, context.DeadlineExceeded, run.Err())
+}
+
+func TestInterruptibleCancel(t *testing.T) {
+	run, cancel := interruptible(time.Hour)
+	assert.NoError(t, run.Err())
+
+	cancel()
+	assert.Equal(t, context.Canceled, run.Err())
+}
+
+func TestInterruptibleNone(t *testing.T) {
+	run, cancel := interruptible(0)
+	assert.NoError(t, run.Err())
+
+	cancel()
+	assert.Equal(t, context.Canceled, run.Err())
+}
+
+func TestSetupProxies(t *testing.T) {
+	baseDir = "../../testdata"
+	configName = "config-proxies.toml"
+
+	ctx, err := setup()
+	require.NoError(t, err)
+	require.Len(t, ctx.Proxies(), 2)
+	assert.Equal(t, "brussels", ctx.Proxies()[0].Name)
+	assert.Equal(t, "bretigny", ctx.Proxies()[1].Name)
+
+	configName = "config.toml"
+}
+
+func TestSetupAllow(t *testing.T) {
+	baseDir = "../../testdata"
+	configName = "config-allow.toml"
+
+	ctx, err := setup()
+	require.NoError(t, err)
+	require.NotNil(t, ctx.Allowlist())
+	assert.Equal(t, "domain microsoft.com", ctx.Allowlist().Host("www.microsoft.com"))
+
+	configName = "config.toml"
+}
+
+func TestSetupPolite(t *testing.T) {
+	baseDir = "../../testdata"
+	configName = "config-polite.toml"
+	fRetries = 1
+
+	ctx, err := setup()
+	require.NoError(t, err)
+	assert.Equal(t, 1, ctx.Config().Retries)
+	assert.Equal(t, 5.0, ctx.Config().Rate)
+
+	fRetries = 0
+	configName = "config.toml"
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, ExitOK, exitCode(nil))
+	assert.Equal(t, ExitError, exitCode(fmt.Errorf("boom")))
+	assert.Equal(t, ExitPartial, exitCode(&PartialError{Unchecked: 1}))
+	assert.Equal(t, ExitPartial, exitCode(errors.Wrap(&PartialError{}, "realmain")))
+}
+
+func TestPartialError(t *testing.T) {
+	err := &PartialError{Unchecked: 2}
+	assert.Equal(t, "partial results, 2 URLs unchecked", err.Error())
+
+	err = &PartialError{Inputs: fmt.Errorf("bad")}
+	assert.Equal(t, "partial results, 0 URLs unchecked: bad", err.Error())
+}
+
+func TestFindCommand(t *testing.T) {
+	c := findCommand("check")
+	require.NotNil(t, c)
+	assert.Equal(t, "check", c.name)
+
+	assert.Nil(t, findCommand("../../testdata/CIMBL-0666-CERTS.csv"))
+}
+
+func TestDump(t *testing.T) {
+	res := cimbl.NewResults()
+	res.Add("url", "http://example.net/b")
+	res.Add("url", "http://example.net/a")
+	res.Add("filename", "foo.exe")
+	res.Add("domain", "example.com")
+
+	var buf bytes.Buffer
+	dump(&buf, res)
+	assert.Equal(t, "url\thttp://example.net/a\nurl\thttp://example.net/b\ndomain\texample.com\nfilename\tfoo.exe\n", buf.String())
+}
+
+func TestRealMain_Parse(t *testing.T) {
+	baseDir = "../../testdata"
+
+	assert.NoError(t, realmain([]string{"parse", "../../testdata/CIMBL-0666-CERTS.csv"}))
+	assert.Error(t, realmain([]string{"parse"}))
+
+	err := realmain([]string{"parse", "../../testdata/CIMBL-0666-CERTS.csv", "/foo.bar"})
+	assert.Equal(t, ExitPartial, exitCode(err))
+}
+
+func TestRealMain_BadFlag(t *testing.T) {
+	assert.Error(t, realmain([]string{"check", "-nope"}))
+}
+
+func TestRealMain_CheckReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cimbl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	baseDir = dir
+
+	// Nothing saved yet
+	assert.NoError(t, realmain([]string{"history"}))
+	assert.Error(t, realmain([]string{"report"}))
+
+	require.NoError(t, realmain([]string{"check", "-U", "../../testdata/CIMBL-0666-CERTS.csv"}))
+
+	names, err := cimbl.NewStore(filepath.Join(dir, "results")).Names()
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+
+	assert.NoError(t, realmain([]string{"history"}))
+	assert.NoError(t, realmain([]string{"report"}))
+	assert.NoError(t, realmain([]string{"report", "-defang", names[0]}))
+	assert.Error(t, realmain([]string{"report", names[0], names[0]}))
+	assert.Error(t, realmain([]string{"report", "nonexistent"}))
+
+	// No mail server
+	assert.Error(t, realmain([]string{"send"}))
+	// No webhook
+	assert.Error(t, realmain([]string{"ticket"}))
+	assert.Error(t, realmain([]string{"ticket", "a", "b"}))
+
+	fNoURLs = false
+	fDefang = false
+}
+
+func TestRealMain_CheckOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cimbl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	baseDir = dir
+	file := filepath.Join(dir, "out.json")
+
+	require.NoError(t, realmain([]string{"check", "-U", "-o", file, "../../testdata/CIMBL-0666-CERTS.csv"}))
+
+	// Not in the store
+	names, err := cimbl.NewStore(filepath.Join(dir, "results")).Names()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	saved, err := cimbl.LoadResults(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"../../testdata/CIMBL-0666-CERTS.csv"}, saved.Results.Meta.Inputs)
+
+	assert.NoError(t, realmain([]string{"report", "-i", file}))
+	assert.Error(t, realmain([]string{"report", "-i", file, "20261019-120812"}))
+	// No blocklist repository
+	assert.Error(t, realmain([]string{"patch", "-i", file}))
+	assert.Error(t, realmain([]string{"patch", "a", "b"}))
+	assert.Error(t, realmain([]string{"report", "-i", filepath.Join(dir, "none.json")}))
+
+	fNoURLs = false
+	fOutput = ""
+	fSaved = ""
+}
+
+func TestRealMain_Template(t *testing.T) {
+	baseDir = "../../testdata"
+
+	assert.NoError(t, realmain([]string{"template"}))
+	assert.NoError(t, realmain([]string{"template", "-text", "../../testdata/report.tmpl", "-html", "../../testdata/report.html"}))
+	fTmplHTML = ""
+	assert.Error(t, realmain([]string{"template", "-text", "../../testdata/bad.tmpl"}))
+	assert.Error(t, realmain([]string{"template", "-text", "../../testdata/broken.tmpl"}))
+	assert.Error(t, realmain([]string{"template", "-text", "", "nonexistent"}))
+	assert.Error(t, realmain([]string{"template", "a", "b"}))
+
+	fTmplText = ""
+
+	assert.NoError(t, realmain([]string{"template", "-order", "threat"}))
+	assert.Error(t, realmain([]string{"template", "-order", "random"}))
+	fOrder = ""
+}
+
+func TestRealMain_CheckNone(t *testing.T) {
+	assert.Error(t, realmain([]string{"check"}))
+}
+
+func TestRealMain_Diff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cimbl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	baseDir = dir
+	file := filepath.Join(dir, "out.json")
+
+	assert.Error(t, realmain([]string{"diff"}))
+	// No blocklist
+	assert.Error(t, realmain([]string{"diff", "../../testdata/indicators.list"}))
+
+	require.NoError(t, realmain([]string{"diff", "-blocklist", "../../testdata/blocklist/proxy.txt", "-o", file, "../../testdata/indicators.list"}))
+	saved, err := cimbl.LoadResults(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{cimbl.DefaultBlocklist}, saved.Results.Meta.Proxies)
+	assert.Equal(t, cimbl.ActionCovered, saved.Results.Verdicts["http://10.1.1.1/"][cimbl.DefaultBlocklist].Action)
+
+	assert.Error(t, realmain([]string{"diff", "-blocklist", "../../testdata/blocklist/proxy.txt", "-blocklist-format", "pac", "../../testdata/indicators.list"}))
+
+	fBlock = ""
+	fBlockFmt = ""
+	fOutput = ""
+}
+
+func TestRealMain_OptionsBeforeCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cimbl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	baseDir = dir
+	file := filepath.Join(dir, "out.json")
+
+	require.NoError(t, flag.CommandLine.Parse([]string{"-blocklist", "../../testdata/blocklist/proxy.txt", "diff", "-o", file, "../../testdata/indicators.list"}))
+	require.NoError(t, realmain(flag.Args()))
+	saved, err := cimbl.LoadResults(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{cimbl.DefaultBlocklist}, saved.Results.Meta.Proxies)
+
+	fBlock = ""
+	fOutput = ""
+}
+
+func TestRealMain_NotifyLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cimbl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	got := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		got = append(got, body["text"])
+	}))
+	defer ts.Close()
+
+	config := "[[notify]]\nname = \"soc\"\nurl = \"" + ts.URL + "\"\nlink = \"https://intranet.example.com/cimbl/%s\"\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte(config), 0600))
+	baseDir = dir
+	configName = "config.toml"
+
+	require.NoError(t, realmain([]string{"check", "-U", "../../testdata/CIMBL-0666-CERTS.csv"}))
+	names, err := cimbl.NewStore(filepath.Join(dir, "results")).Names()
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	require.Len(t, got, 1)
+	assert.Contains(t, got[0], "\nReport: https://intranet.example.com/cimbl/"+names[0])
+
+	// Not in the store, no link
+	require.NoError(t, realmain([]string{"check", "-U", "-o", filepath.Join(dir, "out.json"), "../../testdata/CIMBL-0666-CERTS.csv"}))
+	require.Len(t, got, 2)
+	assert.NotContains(t, got[1], "Report:")
+
+	fNoURLs = false
+	fOutput = ""
+	baseDir = "../../testdata"
+}
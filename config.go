@@ -1,10 +1,10 @@
-package main
+package cimbl
 
 import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -20,11 +20,94 @@ type Config struct {
 
 	// RE to check filenames
 	REFile string `toml:"re_file"`
+
+	// Defang URLs and domains in the mail
+	Defang bool
+
+	// Proxies to check against, the environment one is used if none
+	Proxies []ProxyProfile `toml:"proxy"`
+
+	// DNS firewall to check against, if any
+	DNS *DNSConfig
+
+	// IPExpand is the largest range of an IP list checked address by address
+	IPExpand int `toml:"ip_expand"`
+
+	// Allow lists what must never be blocked
+	Allow *AllowConfig
+
+	// Aggregate URLs into a domain-level block from that many per host or registered domain
+	Aggregate   int
+	AggregateBy string `toml:"aggregate_by"`
+
+	// RPZ zone exported from the results, if any
+	RPZ *RPZConfig
+
+	// Store is where the results of every check are kept
+	Store string
+
+	// Template replaces the built-in report
+	Template *TemplateConfig
+
+	// Routes send some indicator types to other teams
+	Routes []RouteConfig `toml:"route"`
+
+	// Order of the entries in the report: host (default), domain or threat
+	Order string
+
+	// Ticket opens change requests through a webhook
+	Ticket *TicketConfig
+
+	// Notify are the chat webhooks getting a summary of every run
+	Notify []NotifyConfig `toml:"notify"`
+
+	// Blocklist is compared to instead of probing the proxies
+	Blocklist *BlocklistConfig
+
+	// Patch is the blocklist in git the new entries are committed to
+	Patch *PatchConfig
+
+	// Proxy authentication, basic, ntlm or negotiate; the last two use a helper
+	ProxyUser     string `toml:"proxy_user"`
+	ProxyPassword string `toml:"proxy_password"`
+	ProxyAuth     string `toml:"proxy_auth"`
+	ProxyHelper   string `toml:"proxy_helper"`
+
+	// Politeness towards the proxy, Rate is in requests/s, 0 means no limit
+	Rate    float64
+	PerHost int `toml:"per_host"`
+	Retries int
+	Backoff Duration
+	Timeout Duration
+
+	// Probe strategy, methods are tried in order while the answer is one of Fallback
+	Probe      []string
+	ProbeLimit int64 `toml:"probe_limit"`
+	Fallback   []int
+
+	// Rules to interpret the proxy answers, checked before the default ones
+	Rules []Rule `toml:"rule"`
+}
+
+// ProxyProfile is one of our proxy clusters
+type ProxyProfile struct {
+	Name string
+	URL  string
 }
 
-func loadConfig() (*Config, error) {
-	file := filepath.Join(baseDir, configName)
+// Duration is a time.Duration read from a string like "10s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText is for toml
+func (d *Duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
 
+// LoadConfig reads the configuration file, an empty configuration is returned with the error
+func LoadConfig(file string) (*Config, error) {
 	// Check if there is any config file
 	if _, err := os.Stat(file); err != nil {
 		return &Config{}, err
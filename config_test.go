@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"os"
@@ -9,33 +9,24 @@ import (
 )
 
 func TestLoadConfigNone(t *testing.T) {
-	baseDir = "testdata"
-	configName = "/nonexistant"
-
-	c, err := loadConfig()
+	c, err := LoadConfig("/nonexistant")
 	assert.NotNil(t, c)
 	assert.Empty(t, c)
 	assert.Error(t, err)
 }
 
 func TestLoadConfigBad(t *testing.T) {
-	baseDir = "testdata"
-	configName = "bad.toml"
-
-	c, err := loadConfig()
+	c, err := LoadConfig("testdata/bad.toml")
 	assert.Empty(t, c)
 	assert.Error(t, err)
 }
 
 func TestLoadConfigPerms(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config.toml"
-
-	file := filepath.Join(baseDir, configName)
+	file := filepath.Join("testdata", "config.toml")
 	err := os.Chmod(file, 0000)
 	assert.NoError(t, err)
 
-	c, err := loadConfig()
+	c, err := LoadConfig("testdata/config.toml")
 	assert.Empty(t, c)
 	assert.Error(t, err)
 
@@ -44,10 +35,7 @@ func TestLoadConfigPerms(t *testing.T) {
 }
 
 func TestLoadConfigGood(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config.toml"
-
-	c, err := loadConfig()
+	c, err := LoadConfig("testdata/config.toml")
 	assert.NotEmpty(t, c)
 	assert.NoError(t, err)
 
@@ -64,10 +52,7 @@ func TestLoadConfigGood(t *testing.T) {
 }
 
 func TestLoadConfigGood_NoRE(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config-nore.toml"
-
-	c, err := loadConfig()
+	c, err := LoadConfig("testdata/config-nore.toml")
 	assert.NotEmpty(t, c, "not empty")
 	assert.NoError(t, err)
 
@@ -83,11 +68,9 @@ func TestLoadConfigGood_NoRE(t *testing.T) {
 }
 
 func TestLoadConfigGoodVerbose(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config.toml"
-	fVerbose = true
+	SetVerbose(true)
 
-	c, err := loadConfig()
+	c, err := LoadConfig("testdata/config.toml")
 	assert.NotEmpty(t, c, "not empty")
 	assert.NoError(t, err)
 
@@ -101,5 +84,5 @@ func TestLoadConfigGoodVerbose(t *testing.T) {
 		REFile:  `(?i:CIMBL-\d+-(CERTS|EU)\.(csv|zip)(\.asc|))`,
 	}
 	assert.EqualValues(t, cnf, c)
-	fVerbose = false
+	SetVerbose(false)
 }
@@ -0,0 +1,352 @@
+// Package cimbl reads the CIMBL files sent by CERT-EU and other indicator lists, checks them
+// against the web proxies and DNS firewall and reports what is to be blocked.
+package cimbl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"text/template"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/keltia/sandbox"
+	"github.com/pkg/errors"
+)
+
+const (
+	REfn = `(?i:CIMBL-\d+-(CERTS|EU)\.(csv|zip)(\.asc|))`
+)
+
+var (
+	// MyName is the application
+	MyName = "erc-cimbl"
+	// MyVersion is our version, add our features
+	MyVersion = "0.11.0,parallel,resty"
+
+	// DefaultREFile checks filenames when the configuration has no re_file — sensible default
+	DefaultREFile = regexp.MustCompile(REfn)
+)
+
+// Options are what the caller decides for a run, the configuration file being for the site
+type Options struct {
+	// Jobs is the number of parallel checks
+	Jobs int
+	// Input is the type of the inputs, see InputAuto
+	Input string
+	// Strict makes any bad input fatal
+	Strict bool
+
+	// NoURLs & NoPaths skip URLs and filenames
+	NoURLs  bool
+	NoPaths bool
+
+	// Mail really sends the report, otherwise it is displayed
+	Mail bool
+	// Defang URLs and domains in the report
+	Defang bool
+
+	// IPExpand is the largest range of an IP list checked address by address
+	IPExpand int
+	// REFile matches the CIMBL filenames, DefaultREFile if nil
+	REFile *regexp.Regexp
+
+	// Order of the entries in the report, see OrderHost
+	Order string
+}
+
+// reFile is the RE for CIMBL filenames
+func (o Options) reFile() *regexp.Regexp {
+	if o.REFile == nil {
+		return DefaultREFile
+	}
+	return o.REFile
+}
+
+// Context is the way to share info across functions.
+type Context struct {
+	Client *resty.Client
+
+	config  *Config
+	opts    Options
+	tempdir *sandbox.Dir
+	mail    MailSender
+
+	// run is cancelled on SIGINT or when the deadline is reached
+	run context.Context
+
+	proxies []*Proxy
+
+	// What we check with, from the configuration
+	rules    []*rule
+	prober   *Prober
+	throttle *Throttle
+	auth     Authenticator
+	dns      *DNSChecker
+	allow    *Allowlist
+	tmpl     *reportTemplates
+	routes   []*route
+
+	ticket     Ticketer
+	ticketTmpl *template.Template
+	notifiers  []Notifier
+	blocklist  *Blocklist
+}
+
+// NewContext checks the configuration and sets up everything needed to check the indicators.
+// Options complete the configuration: re_file, ip_expand, defang and order are used if not set.
+func NewContext(config *Config, opts Options) (*Context, error) {
+	var err error
+
+	if config == nil {
+		config = &Config{}
+	}
+
+	// No mail server configured but the rest is valid.
+	if config.Server == "" {
+		verbose("no mail server, mail is disabled.")
+		opts.Mail = false
+	} else {
+		verbose("Got mail server %s…", config.Server)
+	}
+
+	if opts.REFile == nil && config.REFile != "" {
+		if opts.REFile, err = regexp.Compile(config.REFile); err != nil {
+			return nil, errors.Wrap(err, "re_file")
+		}
+	}
+	if opts.IPExpand == 0 {
+		opts.IPExpand = config.IPExpand
+	}
+	if config.Defang {
+		opts.Defang = true
+	}
+	if opts.Order == "" {
+		opts.Order = config.Order
+	}
+	if err := checkOrder(opts.Order); err != nil {
+		return nil, err
+	}
+
+	ctx := &Context{
+		config: config,
+		opts:   opts,
+		mail:   SMTPMailSender{},
+	}
+
+	// Our own rules come first, defaults are the fallback
+	ctx.rules, err = compileRules(append(config.Rules, defaultRules...))
+	if err != nil {
+		return nil, errors.Wrap(err, "rules")
+	}
+
+	ctx.prober, err = NewProber(config.Probe, config.ProbeLimit, config.Fallback)
+	if err != nil {
+		return nil, errors.Wrap(err, "probe")
+	}
+
+	ctx.throttle = NewThrottle(config.Rate, config.PerHost, config.Retries, config.Backoff.Duration)
+
+	timeout := config.Timeout.Duration
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	// The proxy comes from the environment, HTTP_PROXY, HTTPS_PROXY & NO_PROXY
+	ctx.Client = resty.New().SetTimeout(timeout).SetRedirectPolicy(keepRedirects)
+
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "http", Host: "example.com"}})
+	if err != nil || proxy == nil {
+		verbose("No proxy: %v", err)
+	} else {
+		verbose("Found proxy variable")
+		debug("Using %s as proxy…", proxy.Host)
+	}
+
+	for _, p := range config.Proxies {
+		if p.Name == "" || p.URL == "" {
+			return nil, fmt.Errorf("proxy needs both name and url")
+		}
+		pc := resty.New().SetProxy(p.URL).SetTimeout(timeout).SetRedirectPolicy(keepRedirects)
+		ctx.proxies = append(ctx.proxies, NewProxy(p.Name, pc))
+		verbose("Using proxy %s (%s)", p.Name, p.URL)
+	}
+
+	if config.DNS != nil {
+		ctx.dns, err = NewDNSChecker(*config.DNS)
+		if err != nil {
+			return nil, errors.Wrap(err, "dns")
+		}
+		verbose("Checking DNS through %s", config.DNS.Resolver)
+	}
+
+	if config.Allow != nil {
+		ctx.allow, err = NewAllowlist(*config.Allow)
+		if err != nil {
+			return nil, errors.Wrap(err, "allow")
+		}
+	}
+
+	ctx.tmpl, err = loadTemplates(config.Template)
+	if err != nil {
+		return nil, errors.Wrap(err, "template")
+	}
+
+	ctx.routes, err = compileRoutes(config, ctx.tmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "routes")
+	}
+
+	if config.Ticket != nil {
+		if config.Ticket.URL == "" {
+			return nil, fmt.Errorf("ticket needs an url")
+		}
+		ctx.ticket = NewWebhookTicketer(*config.Ticket)
+		ctx.ticketTmpl, err = loadTicketTemplate(config.Ticket.Template)
+		if err != nil {
+			return nil, errors.Wrap(err, "ticket template")
+		}
+	}
+
+	for _, nc := range config.Notify {
+		n, err := NewWebhookNotifier(nc)
+		if err != nil {
+			return nil, err
+		}
+		ctx.notifiers = append(ctx.notifiers, n)
+	}
+
+	if config.Blocklist != nil {
+		ctx.blocklist, err = LoadBlocklist(*config.Blocklist)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, pass := credentials(config, proxy)
+	ctx.auth, err = newAuthenticator(config, user, pass)
+	if err != nil {
+		return nil, errors.Wrap(err, "proxy auth")
+	}
+	if ctx.auth != nil {
+		verbose("Proxy auth: %s", ctx.auth.Scheme())
+	}
+
+	// Create our sandbox
+	ctx.tempdir, err = sandbox.New(MyName)
+	if err != nil {
+		return nil, errors.Wrap(err, "setup")
+	}
+
+	return ctx, nil
+}
+
+// Config returns the configuration we were created with
+func (ctx *Context) Config() *Config {
+	return ctx.config
+}
+
+// Options returns the options of the run, completed from the configuration
+func (ctx *Context) Options() Options {
+	return ctx.opts
+}
+
+// Allowlist returns what must never be blocked, nil if nothing
+func (ctx *Context) Allowlist() *Allowlist {
+	return ctx.allow
+}
+
+// Blocklist returns the list compared to instead of the proxies, nil if none
+func (ctx *Context) Blocklist() *Blocklist {
+	return ctx.blocklist
+}
+
+// Proxies returns the configured proxies or the default one, only the blocklist if there is one
+func (ctx *Context) Proxies() []*Proxy {
+	if ctx.blocklist != nil {
+		return []*Proxy{NewProxy(ctx.blocklist.Name, nil)}
+	}
+	if len(ctx.proxies) == 0 {
+		return []*Proxy{NewProxy(DefaultProxy, ctx.Client)}
+	}
+	return ctx.proxies
+}
+
+// Run returns the context of the current run
+func (ctx *Context) Run() context.Context {
+	if ctx.run == nil {
+		return context.Background()
+	}
+	return ctx.run
+}
+
+// SetRun sets the context of the run, to cancel it or give it a deadline
+func (ctx *Context) SetRun(run context.Context) *Context {
+	ctx.run = run
+	return ctx
+}
+
+// SetMailer replaces the SMTP mailer
+func (ctx *Context) SetMailer(m MailSender) *Context {
+	ctx.mail = m
+	return ctx
+}
+
+// SetTicketer replaces the webhook
+func (ctx *Context) SetTicketer(t Ticketer) *Context {
+	ctx.ticket = t
+	return ctx
+}
+
+// SetBlocklist compares to b instead of probing the proxies
+func (ctx *Context) SetBlocklist(b *Blocklist) *Context {
+	ctx.blocklist = b
+	return ctx
+}
+
+// SetNotifiers replaces the webhooks
+func (ctx *Context) SetNotifiers(n ...Notifier) *Context {
+	ctx.notifiers = n
+	return ctx
+}
+
+// Cleanup removes the sandbox
+func (ctx *Context) Cleanup() error {
+	if ctx.tempdir == nil {
+		return nil
+	}
+	return ctx.tempdir.Cleanup()
+}
+
+// workers is the number of parallel checks, at least one
+func (ctx *Context) workers() int {
+	if ctx.opts.Jobs <= 0 {
+		return 1
+	}
+	return ctx.opts.Jobs
+}
+
+// getRules returns the compiled rules, the default ones if none
+func (ctx *Context) getRules() []*rule {
+	if ctx.rules == nil {
+		return builtinRules
+	}
+	return ctx.rules
+}
+
+// getTemplates returns the configured templates or the built-in one
+func (ctx *Context) getTemplates() *reportTemplates {
+	if ctx.tmpl == nil {
+		return builtinTemplates
+	}
+	return ctx.tmpl
+}
+
+// getProber returns the configured prober or the default one
+func (ctx *Context) getProber() *Prober {
+	if ctx.prober == nil {
+		return defaultProber
+	}
+	return ctx.prober
+}
@@ -0,0 +1,109 @@
+package cimbl
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewContext(t *testing.T) {
+	ctx, err := NewContext(nil, Options{Mail: true})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	assert.NotNil(t, ctx.Config())
+	assert.NotNil(t, ctx.tempdir)
+	assert.NotNil(t, ctx.Client)
+	// No mail server
+	assert.False(t, ctx.Options().Mail)
+	assert.Nil(t, ctx.Allowlist())
+}
+
+func TestNewContextOptions(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	require.NoError(t, err)
+	config.IPExpand = 16
+	config.Defang = true
+
+	ctx, err := NewContext(config, Options{Mail: true})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	opts := ctx.Options()
+	assert.True(t, opts.Mail)
+	assert.True(t, opts.Defang)
+	assert.Equal(t, 16, opts.IPExpand)
+	assert.Equal(t, config.REFile, opts.REFile.String())
+
+	// Ours win
+	re := regexp.MustCompile(`\.csv$`)
+	ctx, err = NewContext(config, Options{IPExpand: 4, REFile: re})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	assert.Equal(t, 4, ctx.Options().IPExpand)
+	assert.Equal(t, re, ctx.Options().REFile)
+}
+
+func TestNewContextBadRE(t *testing.T) {
+	ctx, err := NewContext(&Config{REFile: "("}, Options{})
+	assert.Error(t, err)
+	assert.Nil(t, ctx)
+}
+
+func TestNewContextProxies(t *testing.T) {
+	config, err := LoadConfig("testdata/config-proxies.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	require.Len(t, ctx.Proxies(), 2)
+	assert.Equal(t, "brussels", ctx.Proxies()[0].Name)
+	assert.Equal(t, "bretigny", ctx.Proxies()[1].Name)
+}
+
+func TestNewContextAllow(t *testing.T) {
+	config, err := LoadConfig("testdata/config-allow.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	require.NotNil(t, ctx.Allowlist())
+	assert.Equal(t, "domain microsoft.com", ctx.Allowlist().Host("www.microsoft.com"))
+}
+
+func TestContext_Run(t *testing.T) {
+	ctx := &Context{}
+	assert.Equal(t, context.Background(), ctx.Run())
+
+	run, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Equal(t, run, ctx.SetRun(run).Run())
+}
+
+func TestContext_ProxiesDefault(t *testing.T) {
+	ctx := &Context{}
+	require.Len(t, ctx.Proxies(), 1)
+	assert.Equal(t, DefaultProxy, ctx.Proxies()[0].Name)
+}
+
+func TestContext_Workers(t *testing.T) {
+	assert.Equal(t, 1, (&Context{}).workers())
+	assert.Equal(t, 4, (&Context{opts: Options{Jobs: 4}}).workers())
+}
+
+func TestContext_Cleanup(t *testing.T) {
+	assert.NoError(t, (&Context{}).Cleanup())
+}
+
+func TestOptions_REFile(t *testing.T) {
+	assert.Equal(t, DefaultREFile, Options{}.reFile())
+	assert.True(t, Options{}.reFile().MatchString("CIMBL-0666-CERTS.csv"))
+}
@@ -0,0 +1,124 @@
+package cimbl
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DNS verdicts
+const (
+	DNSBlocked  = "BLOCKED-DNS"
+	DNSResolves = "RESOLVES"
+	DNSNotFound = "NXDOMAIN"
+	DNSError    = "DNS-ERROR"
+)
+
+// DNSConfig describes our DNS firewall (RPZ)
+type DNSConfig struct {
+	// Resolver is host:port, the system one is used if empty
+	Resolver string
+	// Sinkholes are the answers meaning "blocked"
+	Sinkholes []string
+	// NXDomain means the RPZ policy is NXDOMAIN (or CNAME .)
+	NXDomain bool `toml:"nxdomain"`
+	Timeout  Duration
+}
+
+// DNSChecker resolves hosts and tells whether the DNS firewall already blocks them
+type DNSChecker struct {
+	r         *net.Resolver
+	sinkholes map[string]bool
+	nxdomain  bool
+	timeout   time.Duration
+}
+
+// NewDNSChecker creates a checker using the given resolver
+func NewDNSChecker(cnf DNSConfig) (*DNSChecker, error) {
+	d := &DNSChecker{
+		r:         net.DefaultResolver,
+		sinkholes: map[string]bool{},
+		nxdomain:  cnf.NXDomain,
+		timeout:   cnf.Timeout.Duration,
+	}
+
+	if d.timeout == 0 {
+		d.timeout = DefaultTimeout
+	}
+
+	for _, s := range cnf.Sinkholes {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.Errorf("invalid sinkhole %s", s)
+		}
+		d.sinkholes[ip.String()] = true
+	}
+
+	if cnf.Resolver != "" {
+		server := cnf.Resolver
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		d.r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var dl net.Dialer
+				return dl.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return d, nil
+}
+
+// Check resolves host and returns the DNS verdict, "" for IP addresses which the RPZ can not block
+func (d *DNSChecker) Check(ctx context.Context, host string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// Absolute name, no search list
+	addrs, err := d.r.LookupIPAddr(ctx, strings.TrimSuffix(host, ".")+".")
+	if err != nil {
+		if de, ok := err.(*net.DNSError); ok && de.IsNotFound {
+			if d.nxdomain {
+				return DNSBlocked, nil
+			}
+			return DNSNotFound, nil
+		}
+		return DNSError, errors.Wrap(err, "lookup")
+	}
+
+	for _, a := range addrs {
+		if d.sinkholes[a.IP.String()] {
+			debug("%s: sinkhole %s", host, a.IP)
+			return DNSBlocked, nil
+		}
+	}
+	return DNSResolves, nil
+}
+
+// checkDNS is the DNS verdict for the host part of str, "" if not configured
+func checkDNS(ctx *Context, str string) string {
+	if ctx.dns == nil || ctx.opts.NoURLs || ctx.blocklist != nil {
+		return ""
+	}
+
+	myurl, err := sanitize(str)
+	if err != nil && err != ErrHttps {
+		return ""
+	}
+	host := hostOf(myurl)
+
+	v, err := ctx.dns.Check(ctx.Run(), host)
+	if err != nil {
+		verbose("dns %s: %v", host, err)
+	}
+	debug("dns %s: %s", host, v)
+	return v
+}
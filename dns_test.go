@@ -0,0 +1,163 @@
+package cimbl
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newFakeDNS is an in-process DNS server answering A queries from zone, NXDOMAIN otherwise
+func newFakeDNS(t *testing.T, zone map[string]string) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var p dnsmessage.Parser
+			hdr, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			name := q.Name.String()
+			ip, ok := zone[name[:len(name)-1]]
+
+			rh := dnsmessage.Header{ID: hdr.ID, Response: true, Authoritative: true}
+			if !ok {
+				rh.RCode = dnsmessage.RCodeNameError
+			}
+			b := dnsmessage.NewBuilder(nil, rh)
+			b.EnableCompression()
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			if ok && q.Type == dnsmessage.TypeA {
+				var a [4]byte
+				copy(a[:], net.ParseIP(ip).To4())
+				b.AResource(dnsmessage.ResourceHeader{
+					Name:  q.Name,
+					Class: dnsmessage.ClassINET,
+					TTL:   60,
+				}, dnsmessage.AResource{A: a})
+			}
+			msg, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(msg, addr)
+		}
+	}()
+	return pc.LocalAddr().String(), func() { pc.Close() }
+}
+
+var testZone = map[string]string{
+	"malware.example.com": "10.6.6.6",
+	"www.example.com":     "192.0.2.1",
+}
+
+func TestNewDNSCheckerBad(t *testing.T) {
+	d, err := NewDNSChecker(DNSConfig{Sinkholes: []string{"not-an-ip"}})
+	assert.Error(t, err)
+	assert.Nil(t, d)
+}
+
+func TestDNSChecker_Check(t *testing.T) {
+	addr, stop := newFakeDNS(t, testZone)
+	defer stop()
+
+	d, err := NewDNSChecker(DNSConfig{Resolver: addr, Sinkholes: []string{"10.6.6.6"}})
+	require.NoError(t, err)
+
+	td := []struct {
+		host string
+		res  string
+	}{
+		{"malware.example.com", DNSBlocked},
+		{"www.example.com", DNSResolves},
+		{"nothere.example.com", DNSNotFound},
+		{"192.0.2.1", ""},
+	}
+	for _, e := range td {
+		v, err := d.Check(context.Background(), e.host)
+		assert.NoError(t, err, e.host)
+		assert.Equal(t, e.res, v, e.host)
+	}
+}
+
+func TestDNSChecker_CheckNXDomain(t *testing.T) {
+	addr, stop := newFakeDNS(t, testZone)
+	defer stop()
+
+	d, err := NewDNSChecker(DNSConfig{Resolver: addr, NXDomain: true})
+	require.NoError(t, err)
+
+	v, err := d.Check(context.Background(), "nothere.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, DNSBlocked, v)
+}
+
+func TestList_CheckDNS(t *testing.T) {
+	addr, stop := newFakeDNS(t, testZone)
+	defer stop()
+
+	dns, _ := NewDNSChecker(DNSConfig{Resolver: addr, Sinkholes: []string{"10.6.6.6"}})
+
+	p, srv := newProxy(t, "a", 403)
+	defer srv.Close()
+
+	ctx := &Context{opts: Options{Jobs: 1}, proxies: []*Proxy{p}, dns: dns}
+
+	l := &List{}
+	l.Add(NewURL("http://www.example.com/malware.exe"))
+	l.Add(NewURL("http://malware.example.com/malware.exe"))
+	l.Add(NewDomain("www.example.com."))
+	l.Add(NewDomain("malware.example.com"))
+
+	res := l.Check(ctx)
+	assert.Empty(t, res.URLs)
+	assert.Equal(t, map[string]bool{"www.example.com": true}, res.Domains)
+	// Blocked by both proxy & DNS, nothing to report
+	assert.Equal(t, map[string]string{"www.example.com": DNSResolves}, res.DNS)
+}
+
+func TestList_CheckDNSAddress(t *testing.T) {
+	addr, stop := newFakeDNS(t, testZone)
+	defer stop()
+
+	dns, _ := NewDNSChecker(DNSConfig{Resolver: addr})
+
+	p, srv := newProxy(t, "a", 200)
+	defer srv.Close()
+
+	ctx := &Context{opts: Options{Jobs: 1}, proxies: []*Proxy{p}, dns: dns}
+
+	l := &List{}
+	l.Add(NewURL("http://192.0.2.1/malware.exe"))
+
+	res := l.Check(ctx)
+	assert.Len(t, res.URLs, 1)
+	assert.Empty(t, res.DNS)
+	assert.Empty(t, resolving(res))
+}
+
+func TestAddDNS(t *testing.T) {
+	res := NewResults().
+		AddDNS("www.example.com", DNSResolves).
+		AddDNS("malware.example.com", DNSBlocked)
+
+	assert.Equal(t, dnsTmpl+"  www.example.com\n", addDNS(&Context{}, res))
+}
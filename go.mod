@@ -10,6 +10,7 @@ require (
 	github.com/nbio/st v0.0.0-20140626010706-e9e8d9816f32 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/net v0.0.0-20190628185345-da137c7871d7
 )
 
 go 1.13
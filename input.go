@@ -0,0 +1,288 @@
+package cimbl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Input types, auto guesses from the name or the content
+const (
+	InputAuto  = "auto"
+	InputCIMBL = "cimbl"
+	InputIP    = "ip"
+	InputList  = "list"
+	InputJSON  = "json"
+)
+
+var (
+	// stdin is where "-" is read from
+	stdin io.Reader = os.Stdin
+
+	// MD5, SHA1 & SHA256
+	reHash = regexp.MustCompile(`^(?:[0-9a-fA-F]{32}|[0-9a-fA-F]{40}|[0-9a-fA-F]{64})$`)
+)
+
+// InputError lists the invalid lines of an input
+type InputError struct {
+	Lines []string
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("%d invalid lines: %s", len(e.Lines), strings.Join(e.Lines, ", "))
+}
+
+// AddInput adds fn, "-" being stdin, read as kind
+func (l *List) AddInput(fn, kind string) (*List, error) {
+	if kind == "" {
+		kind = InputAuto
+	}
+
+	if fn == "-" {
+		return l.AddFromReader(stdin, kind)
+	}
+
+	switch kind {
+	case InputAuto:
+		return l.addAuto(fn)
+	case InputCIMBL:
+		return l.AddFromFile(fn)
+	case InputIP:
+		return l.AddFromIP(fn)
+	case InputList, InputJSON:
+		fh, err := os.Open(fn)
+		if err != nil {
+			return l, errors.Wrapf(err, "unknown fn %s", fn)
+		}
+		defer fh.Close()
+		return l.AddFromReader(fh, kind)
+	}
+	return l, fmt.Errorf("unknown input type %s", kind)
+}
+
+// addAuto guesses from the name, a single URL is accepted as well
+func (l *List) addAuto(e string) (*List, error) {
+	u := Refang(e)
+	switch {
+	case strings.HasPrefix(u, "http:") || strings.HasPrefix(u, "https:"):
+		if _, err := Canonicalize(u); err != nil {
+			return l, errors.Wrapf(err, "invalid URL %s", e)
+		}
+		return l.Add(NewURL(canonURL(u))), nil
+	case strings.HasSuffix(e, ".txt"):
+		return l.AddFromIP(e)
+	case strings.HasSuffix(e, ".json"):
+		return l.AddInput(e, InputJSON)
+	case strings.HasSuffix(e, ".list"):
+		return l.AddInput(e, InputList)
+	case l.opts.reFile().MatchString(e):
+		return l.AddFromFile(e)
+	}
+	return l, fmt.Errorf("invalid filename %s", e)
+}
+
+// AddFromReader reads r as kind, auto looks at the content
+func (l *List) AddFromReader(r io.Reader, kind string) (*List, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return l, errors.Wrap(err, "read")
+	}
+
+	if kind == InputAuto {
+		kind = sniff(buf)
+		debug("input looks like %s", kind)
+	}
+
+	switch kind {
+	case InputCIMBL:
+		return l.ReadFromCSV(bytes.NewReader(buf))
+	case InputIP:
+		entries, err := parseIPList(bytes.NewReader(buf))
+		for _, e := range entries {
+			for _, s := range e.sources(l.opts.IPExpand) {
+				l.Add(s)
+			}
+		}
+		return l, err
+	case InputList:
+		return l.AddFromList(bytes.NewReader(buf))
+	case InputJSON:
+		return l.AddFromJSON(bytes.NewReader(buf))
+	}
+	return l, fmt.Errorf("unknown input type %s", kind)
+}
+
+// sniff guesses the type of buf, JSON arrays, CIMBL CSV or plain lists
+func sniff(buf []byte) string {
+	buf = bytes.TrimSpace(buf)
+	if bytes.HasPrefix(buf, []byte("[")) {
+		return InputJSON
+	}
+
+	first := buf
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		first = buf[:i]
+	}
+	if bytes.Contains(first, []byte("type")) && bytes.Contains(first, []byte("value")) &&
+		bytes.Contains(first, []byte(",")) {
+		return InputCIMBL
+	}
+	return InputList
+}
+
+// classify guesses what a single indicator is, nil if nothing we know.  Ranges up to
+// expand addresses are checked one by one.
+func classify(str string, expand int) []Sourcer {
+	s := Refang(str)
+	if s == "" {
+		return nil
+	}
+
+	if reHash.MatchString(s) {
+		return []Sourcer{NewHash(s)}
+	}
+
+	// Addresses, host:port and ranges
+	if !strings.Contains(s, "://") {
+		e, err := parseIPLine(s)
+		if err == nil && (e.Net != nil || e.Port != "" || net.ParseIP(e.Host) != nil) {
+			return e.sources(expand)
+		}
+	}
+
+	// With a scheme or a path, it is an URL
+	if strings.Contains(s, "://") || strings.Contains(s, "/") {
+		if _, err := Canonicalize(s); err != nil {
+			return nil
+		}
+		return []Sourcer{NewURL(canonURL(s))}
+	}
+
+	if host, err := canonHost(s); err == nil && strings.Contains(host, ".") {
+		return []Sourcer{NewDomain(host)}
+	}
+	return nil
+}
+
+// AddFromList reads one indicator per line, # starts a comment
+func (l *List) AddFromList(r io.Reader) (*List, error) {
+	var bad []string
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		all := classify(line, l.opts.IPExpand)
+		if all == nil {
+			bad = append(bad, fmt.Sprintf("line %d: unknown indicator %q", n, line))
+			continue
+		}
+		for _, e := range all {
+			l.Add(e)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return l, errors.Wrap(err, "list")
+	}
+	if len(bad) != 0 {
+		return l, &InputError{Lines: bad}
+	}
+	return l, nil
+}
+
+// attribute is a MISP-like indicator
+type attribute struct {
+	Type  string      `json:"type"`
+	Value string      `json:"value"`
+	ToIDs interface{} `json:"to_ids"`
+}
+
+// blockable is true unless to_ids is explicitly off
+func (a attribute) blockable() bool {
+	switch v := a.ToIDs.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != "0" && v != "false"
+	}
+	return true
+}
+
+// sources maps the attribute types we know, nil for the others
+func (a attribute) sources(expand int) []Sourcer {
+	switch strings.Split(a.Type, "|")[0] {
+	case "url", "link":
+		if _, err := Canonicalize(a.Value); err != nil {
+			return nil
+		}
+		return []Sourcer{NewURL(canonURL(a.Value))}
+	case "domain", "hostname":
+		return []Sourcer{NewDomain(a.Value)}
+	case "filename":
+		return []Sourcer{NewFilename(strings.Split(a.Value, "|")[0])}
+	case "md5", "sha1", "sha256":
+		return []Sourcer{NewHash(a.Value)}
+	case "ip-dst", "ip-src":
+		return classify(a.Value, expand)
+	}
+	return nil
+}
+
+// AddFromJSON reads an array of indicators, either strings or objects with type & value
+func (l *List) AddFromJSON(r io.Reader) (*List, error) {
+	var (
+		all []json.RawMessage
+		bad []string
+	)
+
+	if err := json.NewDecoder(r).Decode(&all); err != nil {
+		return l, errors.Wrap(err, "json")
+	}
+
+	for i, raw := range all {
+		var (
+			str  string
+			a    attribute
+			srcs []Sourcer
+		)
+
+		if err := json.Unmarshal(raw, &str); err == nil {
+			srcs = classify(str, l.opts.IPExpand)
+		} else if err := json.Unmarshal(raw, &a); err == nil {
+			if !a.blockable() {
+				continue
+			}
+			srcs = a.sources(l.opts.IPExpand)
+		}
+
+		if srcs == nil {
+			bad = append(bad, fmt.Sprintf("item %d: unknown indicator %s", i, string(raw)))
+			continue
+		}
+		for _, e := range srcs {
+			l.Add(e)
+		}
+	}
+	if len(bad) != 0 {
+		return l, &InputError{Lines: bad}
+	}
+	return l, nil
+}
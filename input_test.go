@@ -0,0 +1,133 @@
+package cimbl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniff(t *testing.T) {
+	assert.Equal(t, InputJSON, sniff([]byte(`  ["http://example.com/"]`)))
+	assert.Equal(t, InputCIMBL, sniff([]byte("observable_uuid,kill_chain,type,value,to_ids\n")))
+	assert.Equal(t, InputList, sniff([]byte("http://example.com/\nexample.net\n")))
+	assert.Equal(t, InputList, sniff([]byte("")))
+}
+
+func TestClassify(t *testing.T) {
+	td := []struct {
+		in  string
+		out []Sourcer
+	}{
+		{"http://www.example.com/", []Sourcer{NewURL("http://www.example.com/")}},
+		{"hxxps://www[.]example[.]com/", []Sourcer{NewURL("https://www.example.com/")}},
+		{"www.example.com/foo.php", []Sourcer{NewURL("http://www.example.com/foo.php")}},
+		{"www.example.com:8080", []Sourcer{NewURL("http://www.example.com:8080/")}},
+		{"Evil.Example.com", []Sourcer{NewDomain("evil.example.com")}},
+		{"10.1.1.1", []Sourcer{NewURL("http://10.1.1.1/")}},
+		{"2001:db8::1", []Sourcer{NewURL("http://[2001:db8::1]/")}},
+		{"203.0.113.0/24", []Sourcer{NewNetwork("203.0.113.0/24")}},
+		{"55FE62947F3860108E7798C4498618CB", []Sourcer{NewHash("55fe62947f3860108e7798c4498618cb")}},
+		{"", nil},
+		{"localhost", nil},
+		{"not an indicator", nil},
+		{"ftp://example.com/", nil},
+	}
+	for _, d := range td {
+		assert.Equal(t, d.out, classify(d.in, 0), d.in)
+	}
+}
+
+func TestList_AddFromList(t *testing.T) {
+	td := []Sourcer{
+		NewURL("http://www.example.com/malware.php"),
+		NewURL("https://evil.example.net/login"),
+		NewDomain("evil.example.org"),
+		NewURL("http://www.example.com/other.php"),
+		NewURL("http://10.1.1.1/"),
+		NewURL("http://10.1.1.2:8080/"),
+		NewNetwork("203.0.113.0/24"),
+		NewHash("55fe62947f3860108e7798c4498618cb"),
+	}
+
+	l, err := NewList(nil).AddInput("testdata/indicators.list", InputList)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `line 10: unknown indicator "not an indicator"`)
+	assert.EqualValues(t, td, l.s)
+}
+
+func TestList_AddFromJSON(t *testing.T) {
+	td := []Sourcer{
+		NewURL("http://www.example.com/malware.php"),
+		NewDomain("evil.example.org"),
+		NewURL("http://www.example.net/search.php"),
+		NewDomain("evil.example.com"),
+		NewFilename("evil.exe"),
+		NewHash("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+	}
+
+	l, err := NewList(nil).AddInput("testdata/indicators.json", InputAuto)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 invalid lines")
+	assert.EqualValues(t, td, l.s)
+}
+
+func TestList_AddFromJSON_Bad(t *testing.T) {
+	_, err := NewList(nil).AddFromJSON(strings.NewReader(`{"type": "url"}`))
+	assert.Error(t, err)
+
+	l, err := NewList(nil).AddFromJSON(strings.NewReader(`[{"type": "url", "value": "ftp://ftp.example.com/kit.zip"}]`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ftp://ftp.example.com/kit.zip")
+	assert.Empty(t, l.s)
+}
+
+func TestList_AddInputStdin(t *testing.T) {
+	old := stdin
+	stdin = strings.NewReader("http://www.example.com/\nevil.example.org\n")
+	defer func() { stdin = old }()
+
+	l := NewList([]string{"-"})
+	assert.EqualValues(t, []Sourcer{
+		NewURL("http://www.example.com/"),
+		NewDomain("evil.example.org"),
+	}, l.s)
+}
+
+func TestList_AddInputStdinCSV(t *testing.T) {
+	old := stdin
+	stdin = strings.NewReader("observable_uuid,kill_chain,type,value,to_ids\nfoo,Delivery,url,http://www.example.com/,1\n")
+	defer func() { stdin = old }()
+
+	l, err := NewList(nil).AddInput("-", InputAuto)
+	require.NoError(t, err)
+	assert.EqualValues(t, []Sourcer{NewURL("http://www.example.com/")}, l.s)
+}
+
+func TestList_AddInputTypes(t *testing.T) {
+	l, err := NewList(nil).AddInput("testdata/iplist.txt", InputIP)
+	require.NoError(t, err)
+	assert.Equal(t, 3, l.Length())
+
+	l, err = NewList(nil).AddInput("testdata/CIMBL-0666-CERTS.csv", InputCIMBL)
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Length())
+
+	_, err = NewList(nil).AddInput("testdata/iplist.txt", "xml")
+	assert.Error(t, err)
+
+	_, err = NewList(nil).AddInput("/nonexistent", InputList)
+	assert.Error(t, err)
+}
+
+func TestList_AddInputHTTPS(t *testing.T) {
+	l := NewList([]string{"https://www.example.com/", "foo.doc"})
+	assert.EqualValues(t, []Sourcer{NewURL("https://www.example.com/")}, l.s)
+}
+
+func TestHash(t *testing.T) {
+	r := NewResults()
+	NewHash("ABCDEF").AddTo(r)
+	assert.Equal(t, map[string]bool{"abcdef": true}, r.Hashes)
+}
@@ -0,0 +1,142 @@
+package cimbl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ipEntry is one valid line of an IP list
+type ipEntry struct {
+	// Host is an address or a name
+	Host string
+	Port string
+	// Net is set for ranges
+	Net *net.IPNet
+}
+
+// parseIPLine accepts addresses, [IPv6], host:port, [IPv6]:port and CIDR ranges
+func parseIPLine(line string) (ipEntry, error) {
+	if strings.Contains(line, "/") {
+		_, ipnet, err := net.ParseCIDR(line)
+		if err != nil {
+			return ipEntry{}, fmt.Errorf("invalid range")
+		}
+		return ipEntry{Net: ipnet}, nil
+	}
+
+	// A bare IPv6 address has no port
+	if ip := net.ParseIP(line); ip != nil {
+		return ipEntry{Host: ip.String()}, nil
+	}
+
+	host, port, err := splitHostPort(line)
+	if err != nil {
+		return ipEntry{}, fmt.Errorf("invalid port")
+	}
+	if strings.HasSuffix(line, ":") {
+		return ipEntry{}, fmt.Errorf("invalid port")
+	}
+	if host, err = canonHost(host); err != nil {
+		return ipEntry{}, fmt.Errorf("invalid host")
+	}
+	// No TLD is numeric, this is a bad address
+	if net.ParseIP(host) == nil && strings.Trim(host[strings.LastIndex(host, ".")+1:], "0123456789") == "" {
+		return ipEntry{}, fmt.Errorf("invalid address")
+	}
+	return ipEntry{Host: host, Port: port}, nil
+}
+
+// parseIPList reads one entry per line, # starts a comment.  Valid entries are returned
+// even if some lines are not.
+func parseIPList(r io.Reader) ([]ipEntry, error) {
+	var (
+		entries []ipEntry
+		bad     []string
+	)
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		e, err := parseIPLine(line)
+		if err != nil {
+			bad = append(bad, fmt.Sprintf("line %d: %v %q", n, err, line))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return entries, err
+	}
+	if len(bad) != 0 {
+		return entries, &InputError{Lines: bad}
+	}
+	return entries, nil
+}
+
+// ipURL is the URL we check for host and port
+func ipURL(host, port string) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return canonURL("http://" + host + "/")
+}
+
+// rangeSize is the number of addresses in n, capped to avoid overflows
+func rangeSize(n *net.IPNet) int {
+	ones, bits := n.Mask.Size()
+	if bits-ones > 30 {
+		return 1 << 30
+	}
+	return 1 << uint(bits-ones)
+}
+
+// sources are the URLs to check or the range to report as a whole, ranges larger
+// than expand addresses are not expanded.  A /32 or /128 is always a host.
+func (e ipEntry) sources(expand int) []Sourcer {
+	if e.Net == nil {
+		return []Sourcer{NewURL(ipURL(e.Host, e.Port))}
+	}
+
+	if ones, bits := e.Net.Mask.Size(); ones == bits {
+		return []Sourcer{NewURL(ipURL(e.Net.IP.String(), e.Port))}
+	}
+
+	if rangeSize(e.Net) > expand {
+		return []Sourcer{NewNetwork(e.Net.String())}
+	}
+
+	var all []Sourcer
+	ip := make(net.IP, len(e.Net.IP))
+	copy(ip, e.Net.IP)
+	for ; e.Net.Contains(ip); ip = nextIP(ip) {
+		all = append(all, NewURL(ipURL(ip.String(), "")))
+	}
+	return all
+}
+
+// nextIP is ip+1, wrapping around
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
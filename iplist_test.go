@@ -0,0 +1,112 @@
+package cimbl
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIPLine(t *testing.T) {
+	td := []struct {
+		in   string
+		host string
+		port string
+	}{
+		{"10.1.1.1", "10.1.1.1", ""},
+		{"10.1.1.1:8080", "10.1.1.1", "8080"},
+		{"2001:db8::1", "2001:db8::1", ""},
+		{"[2001:db8::1]", "2001:db8::1", ""},
+		{"[2001:db8::1]:8443", "2001:db8::1", "8443"},
+		{"Host.Example.com:81", "host.example.com", "81"},
+	}
+	for _, d := range td {
+		e, err := parseIPLine(d.in)
+		require.NoError(t, err, d.in)
+		assert.Equal(t, d.host, e.Host, d.in)
+		assert.Equal(t, d.port, e.Port, d.in)
+		assert.Nil(t, e.Net)
+	}
+}
+
+func TestParseIPLine_Range(t *testing.T) {
+	e, err := parseIPLine("198.51.100.1/30")
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.0/30", e.Net.String())
+}
+
+func TestParseIPLine_Bad(t *testing.T) {
+	for _, in := range []string{"10.1.1.0/33", "10.1.1.1:", "10.1.1.1:http", "[10.1.1", "not an address", "[foo]", "10.1.1.300"} {
+		_, err := parseIPLine(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestParseIPList(t *testing.T) {
+	in := "# comment\n10.1.1.1\n\n  172.16.1.1  # inline\nbogus host\n"
+
+	entries, err := parseIPList(strings.NewReader(in))
+	require.Error(t, err)
+	assert.Len(t, entries, 2)
+
+	lerr, ok := err.(*InputError)
+	require.True(t, ok)
+	assert.Equal(t, []string{`line 5: invalid host "bogus host"`}, lerr.Lines)
+	assert.Contains(t, err.Error(), "1 invalid lines")
+}
+
+func TestParseIPList_Good(t *testing.T) {
+	entries, err := parseIPList(strings.NewReader("10.1.1.1\n10.1.1.2\n"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestIPEntry_Sources(t *testing.T) {
+	e := ipEntry{Host: "2001:db8::1", Port: "8443"}
+	assert.Equal(t, []Sourcer{NewURL("http://[2001:db8::1]:8443/")}, e.sources(0))
+
+	e = ipEntry{Host: "10.1.1.1", Port: "80"}
+	assert.Equal(t, []Sourcer{NewURL("http://10.1.1.1/")}, e.sources(0))
+}
+
+func TestIPEntry_SourcesRange(t *testing.T) {
+	_, n, err := net.ParseCIDR("198.51.100.0/30")
+	require.NoError(t, err)
+
+	e := ipEntry{Net: n}
+	assert.Equal(t, []Sourcer{NewNetwork("198.51.100.0/30")}, e.sources(0))
+	assert.Equal(t, []Sourcer{
+		NewURL("http://198.51.100.0/"),
+		NewURL("http://198.51.100.1/"),
+		NewURL("http://198.51.100.2/"),
+		NewURL("http://198.51.100.3/"),
+	}, e.sources(4))
+}
+
+func TestIPEntry_SourcesSingle(t *testing.T) {
+	for in, want := range map[string]string{
+		"198.51.100.7/32": "http://198.51.100.7/",
+		"2001:db8::7/128": "http://[2001:db8::7]/",
+	} {
+		_, n, err := net.ParseCIDR(in)
+		require.NoError(t, err)
+
+		e := ipEntry{Net: n}
+		assert.Equal(t, []Sourcer{NewURL(want)}, e.sources(0), in)
+	}
+}
+
+func TestRangeSize(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	assert.Equal(t, 256, rangeSize(n))
+
+	_, n, _ = net.ParseCIDR("2001:db8::/32")
+	assert.Equal(t, 1<<30, rangeSize(n))
+}
+
+func TestNextIP(t *testing.T) {
+	assert.Equal(t, "10.0.1.0", nextIP(net.ParseIP("10.0.0.255").To4()).String())
+	assert.Equal(t, "2001:db8::1:0", nextIP(net.ParseIP("2001:db8::ffff")).String())
+}
@@ -1,38 +1,64 @@
-package main
+package cimbl
 
 import (
-	"bytes"
 	"fmt"
 	"log"
 	"net/smtp"
 	"strings"
-	"text/template"
 
 	"github.com/pkg/errors"
 )
 
 var (
-	mailTmpl = `Subject: {{.Subject}}
-To: {{.To}}
-Cc: {{.Cc}}
-X-Contact-Info: {{.From}}
-
-Dear Service Desk,
+	// mailTmpl is the built-in body, headers are added from the configuration
+	mailTmpl = `Dear Service Desk,
 
 After reading the following files received from CERT-EU:
   {{.Files}}
 
-{{.URLs}}
-{{.Paths}}
+{{if .URLs}}{{.URLs}}
+{{end -}}
+{{if .Domains}}{{.Domains}}
+{{end -}}
+{{if .Aggregated}}{{.Aggregated}}
+{{end -}}
+{{if .Networks}}{{.Networks}}
+{{end -}}
+{{if .Paths}}{{.Paths}}
+{{end -}}
+{{if .Hashes}}{{.Hashes}}
+{{end -}}
+{{if .DNS}}{{.DNS}}
+{{end -}}
+{{if .Unchecked}}{{.Unchecked}}
+{{end -}}
+{{if .Allowed}}{{.Allowed}}
+{{end -}}
 Best regards,
 --
 Your friendly script - {{.MyName}}/{{.MyVersion}}
 `
 
-	pathsTmpl = "Please add the following to the list of blocked filenames:\n"
-	urlsTmpl  = "Please add the following to the list of blocked URLs on BlueCoat:\n"
+	pathsTmpl  = "Please add the following to the list of blocked filenames:\n"
+	hashesTmpl = "Please add the following to the list of blocked file hashes:\n"
+	urlsTmpl   = "Please add the following to the list of blocked URLs on BlueCoat:\n"
+
+	proxyURLsTmpl    = "Please add the following to the list of blocked URLs on %s:\n"
+	proxyDomainsTmpl = "Please add the following to the list of blocked domains on %s (HTTPS, no path blocking without MITM):\n"
+
+	aggregatedTmpl = "Please add the following to the list of blocked domains on BlueCoat (too many URLs to block them one by one):\n"
+
+	networksTmpl = "Please add the following ranges to the firewall blocklist:\n"
+
+	dnsTmpl = "Please add the following hosts to the DNS firewall (RPZ):\n"
+
+	uncheckedTmpl = "The following URLs were NOT checked (run interrupted or unsupported URL), please block them by hand:\n"
+
+	allowedTmpl = "The following were NOT asked to be blocked because of the allowlist, please review:\n"
+
+	domainsTmpl = "Please add the following to the list of blocked domains on BlueCoat (HTTPS, no path blocking without MITM):\n"
 
-	skipped = []string{}
+	indicatorDomainsTmpl = "Please add the following domains to the list of blocked domains on BlueCoat:\n"
 )
 
 type MailSender interface {
@@ -56,51 +82,46 @@ Body
 	return nil
 }
 
-type mailVars struct {
-	From      string
-	To        string
-	Cc        string
-	Subject   string
-	MyName    string
-	MyVersion string
-	URLs      string
-	Paths     string
-	Files     string
-}
-
 func createMail(ctx *Context, res *Results) (str string, err error) {
-	var txt bytes.Buffer
+	return routeMail(ctx, res, nil)
+}
 
+// routeMail renders the mail for one team, the global one if rt is nil
+func routeMail(ctx *Context, res *Results, rt *route) (string, error) {
 	if ctx == nil {
 		return "", fmt.Errorf("null context")
 	}
 	if ctx.config == nil {
 		return "", fmt.Errorf("null config")
 	}
-	vars := mailVars{
-		From:      ctx.config.From,
-		To:        ctx.config.To,
-		Cc:        ctx.config.Cc,
-		Subject:   ctx.config.Subject,
-		MyName:    MyName,
-		MyVersion: MyVersion,
-		Files:     strings.Join(res.files, ", "),
-		Paths:     addPaths(res),
-		URLs:      addURLs(res),
+
+	d := newReportData(ctx, res)
+	tmpl := ctx.getTemplates()
+	if rt != nil {
+		d.To, d.Cc = rt.to, rt.cc
+		if rt.subject != "" {
+			d.Subject = rt.subject
+		}
+		tmpl = rt.tmpl
 	}
+	return tmpl.render(d)
+}
 
-	t := template.Must(template.New("mail").Parse(mailTmpl))
-	err = t.Execute(&txt, vars)
-	return txt.String(), err
+// display defangs the indicators if asked to
+func display(ctx *Context, str string) string {
+	if ctx.opts.Defang {
+		return Defang(str)
+	}
+	return str
 }
 
-func addPaths(res *Results) string {
+func addPaths(ctx *Context, res *Results) string {
 	var txt string
 
-	if !fNoPaths {
+	if !ctx.opts.NoPaths {
 		if len(res.Paths) != 0 {
 			txt = fmt.Sprintf("%s", pathsTmpl)
-			for k := range res.Paths {
+			for _, k := range ctx.sorted(res, keysOf(res.Paths)) {
 				txt = fmt.Sprintf("%s  %s\n", txt, k)
 			}
 		}
@@ -108,64 +129,280 @@ func addPaths(res *Results) string {
 	return txt
 }
 
-func addURLs(res *Results) string {
+func addHashes(ctx *Context, res *Results) string {
 	var txt string
 
-	if !fNoURLs {
+	if !ctx.opts.NoPaths {
+		if len(res.Hashes) != 0 {
+			txt = fmt.Sprintf("%s", hashesTmpl)
+			for _, k := range ctx.sorted(res, keysOf(res.Hashes)) {
+				txt = fmt.Sprintf("%s  %s\n", txt, k)
+			}
+		}
+	}
+	return txt
+}
+
+func addURLs(ctx *Context, res *Results) string {
+	var txt string
+
+	if !ctx.opts.NoURLs {
 		if len(res.URLs) != 0 {
 			txt = fmt.Sprintf("%s", urlsTmpl)
-			for k := range res.URLs {
-				txt = fmt.Sprintf("%s  %s\n", txt, k)
+			for _, k := range ctx.sorted(res, keysOf(res.URLs)) {
+				txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
 			}
 		}
 	}
 	return txt
 }
 
-func doSendMail(ctx *Context, res *Results) (err error) {
-	if len(res.Paths) != 0 || len(res.URLs) != 0 {
-		mailText, err := createMail(ctx, res)
-		if err != nil {
-			return errors.Wrap(err, "createMail")
+// httpsDomains splits the domains between the hosts of https URLs and the domain indicators
+func httpsDomains(res *Results) (map[string]bool, map[string]bool) {
+	hosts := map[string]bool{}
+	for u := range res.Verdicts {
+		if host, ok := httpsHost(u); ok && res.Domains[host] {
+			hosts[host] = true
 		}
+	}
 
-		// Really sendmail now
-		if fDoMail {
-			verbose("Sending the mail")
-			return sendMail(ctx, mailText)
+	others := map[string]bool{}
+	for d := range res.Domains {
+		if !hosts[d] {
+			others[d] = true
 		}
+	}
+	return hosts, others
+}
 
-		// Otherwise, display it
-		fmt.Printf("From: %s\n", ctx.config.From)
-		fmt.Printf("Cc: %s\n", ctx.config.Cc)
-		fmt.Println(mailText)
-	} else {
+// addDomains lists the https hosts then the domain indicators
+func addDomains(ctx *Context, res *Results) string {
+	var txt string
+
+	if !ctx.opts.NoURLs {
+		hosts, others := httpsDomains(res)
+		if len(hosts) != 0 {
+			txt = fmt.Sprintf("%s", domainsTmpl)
+			for _, k := range ctx.sorted(res, keysOf(hosts)) {
+				txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
+			}
+		}
+		if len(others) != 0 {
+			if txt != "" {
+				txt += "\n"
+			}
+			txt += indicatorDomains(ctx, res, others)
+		}
+	}
+	return txt
+}
+
+// addIndicatorDomains lists only the domain indicators, the https hosts being per proxy
+func addIndicatorDomains(ctx *Context, res *Results) string {
+	if ctx.opts.NoURLs {
+		return ""
+	}
+	_, others := httpsDomains(res)
+	if len(others) == 0 {
+		return ""
+	}
+	return indicatorDomains(ctx, res, others)
+}
+
+func indicatorDomains(ctx *Context, res *Results, others map[string]bool) string {
+	txt := fmt.Sprintf("%s", indicatorDomainsTmpl)
+	for _, k := range ctx.sorted(res, keysOf(others)) {
+		txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
+	}
+	return txt
+}
+
+func addAggregated(ctx *Context, res *Results) string {
+	var txt string
+
+	if !ctx.opts.NoURLs {
+		if len(res.Aggregated) != 0 {
+			txt = fmt.Sprintf("%s", aggregatedTmpl)
+			for _, k := range ctx.sorted(res, aggregatedKeys(res)) {
+				txt = fmt.Sprintf("%s  %s (%d URLs)\n", txt, display(ctx, k), len(res.Aggregated[k]))
+			}
+		}
+	}
+	return txt
+}
+
+func addNetworks(ctx *Context, res *Results) string {
+	var txt string
+
+	if !ctx.opts.NoURLs {
+		if len(res.Networks) != 0 {
+			txt = fmt.Sprintf("%s", networksTmpl)
+			for _, k := range ctx.sorted(res, keysOf(res.Networks)) {
+				txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
+			}
+		}
+	}
+	return txt
+}
+
+func addPerProxy(ctx *Context, res *Results, names []string) string {
+	var txt string
+
+	if ctx.opts.NoURLs {
+		return txt
+	}
+
+	for _, name := range names {
+		urls, domains := res.PassedBy(name)
+		if len(urls) != 0 {
+			if txt != "" {
+				txt += "\n"
+			}
+			txt = fmt.Sprintf("%s"+proxyURLsTmpl, txt, name)
+			for _, k := range ctx.sorted(res, keysOf(urls)) {
+				txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
+			}
+		}
+		if len(domains) != 0 {
+			if txt != "" {
+				txt += "\n"
+			}
+			txt = fmt.Sprintf("%s"+proxyDomainsTmpl, txt, name)
+			for _, k := range ctx.sorted(res, keysOf(domains)) {
+				txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
+			}
+		}
+	}
+	return txt
+}
+
+func addDNS(ctx *Context, res *Results) string {
+	var txt string
+
+	if !ctx.opts.NoURLs {
+		for _, k := range ctx.sorted(res, resolving(res)) {
+			if txt == "" {
+				txt = fmt.Sprintf("%s", dnsTmpl)
+			}
+			txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
+		}
+	}
+	return txt
+}
+
+func addUnchecked(ctx *Context, res *Results) string {
+	var txt string
+
+	if len(res.Unchecked) != 0 {
+		txt = fmt.Sprintf("%s", uncheckedTmpl)
+		for _, k := range ctx.sorted(res, keysOf(res.Unchecked)) {
+			txt = fmt.Sprintf("%s  %s\n", txt, display(ctx, k))
+		}
+	}
+	return txt
+}
+
+func addAllowed(ctx *Context, res *Results) string {
+	var txt string
+
+	if len(res.Allowed) != 0 {
+		txt = fmt.Sprintf("%s", allowedTmpl)
+		for _, k := range ctx.sorted(res, allowedKeys(res)) {
+			txt = fmt.Sprintf("%s  %s (%s)\n", txt, display(ctx, k), res.Allowed[k])
+		}
+	}
+	return txt
+}
+
+// SendReport mails the report, one per team if there are routes, or displays it if the options say so
+func SendReport(ctx *Context, res *Results) (err error) {
+	if res.Empty() {
 		log.Print("Nothing to do…")
+		return nil
+	}
+
+	if len(ctx.routes) == 0 {
+		return sendReport(ctx, res, nil)
+	}
+	for _, rt := range ctx.routes {
+		part := res.only(rt.types)
+		if part.Empty() {
+			verbose("Nothing for %s", rt.name)
+			continue
+		}
+		if err := sendReport(ctx, part, rt); err != nil {
+			return errors.Wrap(err, rt.name)
+		}
+	}
+	return nil
+}
+
+// sendReport mails or displays the report for one team, the global one if rt is nil
+func sendReport(ctx *Context, res *Results, rt *route) error {
+	mailText, err := routeMail(ctx, res, rt)
+	if err != nil {
+		return errors.Wrap(err, "createMail")
+	}
+
+	to, cc := ctx.config.To, ctx.config.Cc
+	if rt != nil {
+		to, cc = rt.to, rt.cc
+	}
+
+	// Really sendmail now
+	if ctx.opts.Mail {
+		verbose("Sending the mail")
+		return sendMail(ctx, to, cc, mailText)
 	}
 
+	// Otherwise, display it
+	fmt.Printf("From: %s\n", ctx.config.From)
+	fmt.Printf("Cc: %s\n", cc)
+	fmt.Println(mailText)
 	return nil
 }
 
-func sendMail(ctx *Context, text string) (err error) {
+// Reports renders the mails SendReport would send, one per team with something for it
+func (ctx *Context) Reports(res *Results) ([]string, error) {
+	if len(ctx.routes) == 0 {
+		txt, err := createMail(ctx, res)
+		return []string{txt}, err
+	}
+
+	all := []string{}
+	for _, rt := range ctx.routes {
+		part := res.only(rt.types)
+		if part.Empty() {
+			continue
+		}
+		txt, err := routeMail(ctx, part, rt)
+		if err != nil {
+			return nil, errors.Wrap(err, rt.name)
+		}
+		all = append(all, txt)
+	}
+	return all, nil
+}
+
+func sendMail(ctx *Context, rcpt, cc, text string) (err error) {
 	var to []string
 
 	verbose("Connecting to %s…", ctx.config.Server)
 	from := ctx.config.From
 
 	// Debug mode only send to me
-	if fDebug {
+	if logDebug {
 		to = []string{from}
 	} else {
-		to = strings.Split(ctx.config.To, ",")
-		if ctx.config.Cc != "" {
-			cc := strings.Split(ctx.config.Cc, ",")
-			to = append(to, cc...)
+		to = strings.Split(rcpt, ",")
+		if cc != "" {
+			to = append(to, strings.Split(cc, ",")...)
 		}
 	}
 
 	debug("from: %s - To: %v", from, to)
 
-	if fDebug {
+	if logDebug {
 		verbose("null mailer")
 		ctx.mail = NullMailer{}
 	}
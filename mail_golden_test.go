@@ -0,0 +1,87 @@
+package cimbl
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files")
+
+// goldenResults are the sample ones with enough URLs to see the order
+func goldenResults() *Results {
+	r := SampleResults()
+	for _, u := range []string{"http://z.example.net/b", "http://a.example.org/x", "https://www.example.net/login", "http://z.example.net/a"} {
+		r.Add("url", u)
+		r.AddVerdict(u, DefaultProxy, Verdict{Action: u, Method: "HEAD", Code: 200})
+	}
+	r.Add("domain", "www.example.net")
+	r.Add("network", "10.0.0.0/8")
+	r.Add("hash", "fedcba9876543210fedcba9876543210")
+	r.Add("filename", "agenda.xlsm")
+	r.AddDNS("a.example.org", DNSResolves)
+	r.AddDNS("blocked.example.org", DNSBlocked)
+	return r
+}
+
+// perProxyResults have verdicts from two proxies and threat levels
+func perProxyResults() *Results {
+	r := NewResults()
+	r.files = []string{"CIMBL-0666-CERTS.csv"}
+	for _, u := range []string{"http://z.example.com/malware", "http://a.example.com/malware", "https://secure.example.net/login"} {
+		r.Add("url", u)
+		r.AddVerdict(u, "brussels", Verdict{Action: u})
+		r.AddVerdict(u, "bretigny", Verdict{Action: u})
+	}
+	r.AddVerdict("http://a.example.com/malware", "brussels", Verdict{Action: ActionBlocked})
+	r.AddThreat("http://z.example.com/malware", "High")
+	r.AddThreat("http://a.example.com/malware", "Medium")
+	return r
+}
+
+func TestCreateMailGolden(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opts Options
+		tmpl *TemplateConfig
+		res  func() *Results
+	}{
+		{"builtin", Options{}, nil, goldenResults},
+		{"defang", Options{Defang: true}, nil, goldenResults},
+		{"domain", Options{Order: OrderDomain}, nil, goldenResults},
+		{"threat", Options{Order: OrderThreat}, nil, perProxyResults},
+		{"perproxy", Options{}, nil, perProxyResults},
+		{"template", Options{}, &TemplateConfig{Text: "testdata/report.tmpl", HTML: "testdata/report.html"}, goldenResults},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := loadTemplates(tt.tmpl)
+		require.NoError(t, err)
+		ctx := &Context{config: config, opts: tt.opts, tmpl: tmpl}
+
+		txt, err := createMail(ctx, tt.res())
+		require.NoError(t, err, tt.name)
+
+		// Always the same mail for the same results
+		again, err := createMail(ctx, tt.res())
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, txt, again, tt.name)
+
+		golden := filepath.Join("testdata", "golden", tt.name+".mail")
+		if *update {
+			require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0755))
+			require.NoError(t, ioutil.WriteFile(golden, []byte(txt), 0644))
+		}
+		want, err := ioutil.ReadFile(golden)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, string(want), txt, tt.name)
+	}
+}
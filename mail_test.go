@@ -1,10 +1,11 @@
-package main
+package cimbl
 
 import (
 	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateMailNilContext(t *testing.T) {
@@ -24,7 +25,7 @@ func TestAddPaths(t *testing.T) {
 	results := &Results{Paths: map[string]bool{"foo.docx": true}}
 
 	res := fmt.Sprintf("%s  %s\n", pathsTmpl, "foo.docx")
-	str := addPaths(results)
+	str := addPaths(&Context{}, results)
 	assert.Equal(t, res, str, "should be equal")
 }
 
@@ -32,84 +33,146 @@ func TestAddURLsBlock(t *testing.T) {
 	results := &Results{URLs: map[string]bool{"http://example.com/malware": true}}
 
 	res := fmt.Sprintf("%s  %s\n", urlsTmpl, "http://example.com/malware")
-	str := addURLs(results)
+	str := addURLs(&Context{}, results)
 	assert.Equal(t, res, str, "should be equal")
 
 }
 
-func TestDoSendMailNoMail(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config.toml"
-	fVerbose = false
+func TestAddURLsDefang(t *testing.T) {
+	ctx := &Context{opts: Options{Defang: true}}
+	results := &Results{URLs: map[string]bool{"http://example.com/malware": true}}
+
+	res := fmt.Sprintf("%s  %s\n", urlsTmpl, "hxxp://example[.]com/malware")
+	str := addURLs(ctx, results)
+	assert.Equal(t, res, str)
+}
+
+func TestAddDomains(t *testing.T) {
+	results := &Results{Domains: map[string]bool{"example.com": true}}
+
+	res := fmt.Sprintf("%s  %s\n", indicatorDomainsTmpl, "example.com")
+	str := addDomains(&Context{}, results)
+	assert.Equal(t, res, str, "should be equal")
+}
+
+func TestAddDomainsHTTPS(t *testing.T) {
+	results := NewResults()
+	results.Add("domain", "example.com")
+	results.Add("domain", "www.example.net")
+	results.AddVerdict("https://www.example.net/login", DefaultProxy, Verdict{Action: "https://www.example.net/login"})
+
+	res := fmt.Sprintf("%s  %s\n\n%s  %s\n", domainsTmpl, "www.example.net", indicatorDomainsTmpl, "example.com")
+	assert.Equal(t, res, addDomains(&Context{}, results))
+}
+
+func TestAddAggregated(t *testing.T) {
+	results := &Results{Aggregated: map[string][]string{
+		"example.com": {"http://www.example.com/a", "http://www.example.com/b"},
+	}}
 
-	config, err := loadConfig()
+	res := fmt.Sprintf("%s  %s\n", aggregatedTmpl, "example.com (2 URLs)")
+	str := addAggregated(&Context{}, results)
+	assert.Equal(t, res, str)
+}
+
+func TestAddAllowed(t *testing.T) {
+	results := &Results{Allowed: map[string]string{"microsoft.com": "domain microsoft.com"}}
+
+	res := fmt.Sprintf("%s  %s\n", allowedTmpl, "microsoft.com (domain microsoft.com)")
+	str := addAllowed(&Context{}, results)
+	assert.Equal(t, res, str)
+}
+
+func TestAddNetworks(t *testing.T) {
+	results := &Results{Networks: map[string]bool{"203.0.113.0/24": true}}
+
+	res := fmt.Sprintf("%s  %s\n", networksTmpl, "203.0.113.0/24")
+	str := addNetworks(&Context{}, results)
+	assert.Equal(t, res, str)
+}
+
+func TestSendReportNoMail(t *testing.T) {
+	SetVerbose(false)
+
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err, "no error")
 	ctx := &Context{
 		config: config,
 	}
 	res := &Results{Paths: map[string]bool{"foo.docx": true}}
 
-	err = doSendMail(ctx, res)
+	err = SendReport(ctx, res)
 	assert.NoError(t, err, "no error")
 }
 
-func TestDoSendMailConfigError(t *testing.T) {
+func TestSendReportConfigError(t *testing.T) {
 	ctx := &Context{config: nil}
 	res := &Results{Paths: map[string]bool{"/dontcare": true}}
 
-	err := doSendMail(ctx, res)
+	err := SendReport(ctx, res)
 	assert.Error(t, err)
 }
 
-func TestDoSendMailNoWork(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config.toml"
-	fVerbose = false
+func TestSendReportNoWork(t *testing.T) {
+	SetVerbose(false)
 
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	ctx := &Context{config: config}
 	res := &Results{Paths: map[string]bool{}}
 
-	err = doSendMail(ctx, res)
+	err = SendReport(ctx, res)
 	assert.NoError(t, err, "no error")
 }
 
-func TestDoSendMailWithMail(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config.toml"
-	fVerbose = false
+func TestSendReportWithMail(t *testing.T) {
+	SetVerbose(false)
 
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err, "no error")
 	ctx := &Context{
 		config: config,
+		opts:   Options{Mail: true},
 		mail:   NullMailer{},
 	}
 	res := &Results{Paths: map[string]bool{"foo.docx": true}}
-	fDoMail = true
 
-	err = doSendMail(ctx, res)
+	err = SendReport(ctx, res)
 	assert.NoError(t, err, "no error")
 }
 
-func TestDoSendMailWithMailDebug(t *testing.T) {
-	baseDir = "testdata"
-	configName = "config.toml"
-	fDebug = true
+func TestSendReportAllowed(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	require.NoError(t, err)
+	m := &recordMailer{}
+	ctx := &Context{
+		config: config,
+		opts:   Options{Mail: true},
+		mail:   m,
+	}
+	res := NewResults().AddAllowed("update.microsoft.com", "domain microsoft.com")
+
+	// Still to be reviewed
+	require.NoError(t, SendReport(ctx, res))
+	require.Len(t, m.text, 1)
+	assert.Contains(t, m.text[0], "update.microsoft.com (domain microsoft.com)")
+}
+
+func TestSendReportWithMailDebug(t *testing.T) {
+	SetDebug(true)
 
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err, "no error")
 	ctx := &Context{
 		config: config,
+		opts:   Options{Mail: true},
 		mail:   NullMailer{},
 	}
 	res := &Results{Paths: map[string]bool{"foo.docx": true}}
-	fDoMail = true
 
-	err = doSendMail(ctx, res)
+	err = SendReport(ctx, res)
 	assert.NoError(t, err, "no error")
-	fDebug = false
+	SetDebug(false)
 }
 
 func TestSMTPMailSender_SendMail(t *testing.T) {
@@ -117,3 +180,45 @@ func TestSMTPMailSender_SendMail(t *testing.T) {
 	err := m.SendMail("", "", nil, nil)
 	assert.Error(t, err)
 }
+
+func TestAddUnchecked(t *testing.T) {
+	results := &Results{Unchecked: map[string]bool{"http://example.com/malware": true}}
+
+	res := fmt.Sprintf("%s  %s\n", uncheckedTmpl, "http://example.com/malware")
+	str := addUnchecked(&Context{}, results)
+	assert.Equal(t, res, str, "should be equal")
+}
+
+func TestCreateMailPerProxy(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	assert.NoError(t, err)
+	ctx := &Context{config: config}
+
+	res := NewResults()
+	res.Add("url", "http://example.com/malware")
+	res.AddVerdict("http://example.com/malware", "brussels", Verdict{Action: ActionBlocked})
+	res.AddVerdict("http://example.com/malware", "bretigny", Verdict{Action: "http://example.com/malware"})
+
+	txt, err := createMail(ctx, res)
+	assert.NoError(t, err)
+	assert.Contains(t, txt, fmt.Sprintf(proxyURLsTmpl, "bretigny")+"  http://example.com/malware\n")
+	assert.NotContains(t, txt, fmt.Sprintf(proxyURLsTmpl, "brussels"))
+	assert.NotContains(t, txt, urlsTmpl)
+}
+
+func TestCreateMailPerProxyDomains(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	assert.NoError(t, err)
+	ctx := &Context{config: config}
+
+	res := NewResults()
+	res.Add("url", "http://example.com/malware")
+	res.Add("domain", "evil.example.org")
+	res.AddVerdict("http://example.com/malware", "brussels", Verdict{Action: ActionBlocked})
+	res.AddVerdict("http://example.com/malware", "bretigny", Verdict{Action: "http://example.com/malware"})
+
+	txt, err := createMail(ctx, res)
+	assert.NoError(t, err)
+	assert.Contains(t, txt, indicatorDomainsTmpl+"  evil.example.org\n")
+	assert.Contains(t, txt, fmt.Sprintf(proxyURLsTmpl, "bretigny"))
+}
@@ -0,0 +1,217 @@
+package cimbl
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+// Chat webhook kinds, Mattermost takes the Slack format
+const (
+	NotifySlack      = "slack"
+	NotifyMattermost = "mattermost"
+	NotifyTeams      = "teams"
+)
+
+// NotifyConfig is one incoming webhook getting a summary of every run
+//
+// [[notify]]
+// name = "soc"
+// url = "https://hooks.slack.com/services/xxx"
+// kind = "slack"
+// link = "https://intranet.example.com/cimbl/%s"
+type NotifyConfig struct {
+	Name string
+	URL  string
+	// Kind is slack (default), mattermost or teams
+	Kind string
+	// Link to the full report, %s being replaced by its name
+	Link    string
+	Timeout Duration
+}
+
+// Summary is the short version of the report
+type Summary struct {
+	Program string
+	Files   []string
+	// Counts are per indicator type, see IndicatorTypes
+	Counts map[string]int
+	// Verdicts are the proxy answers per verdict: block, blocked, auth, ignore and unchecked,
+	// or listed, covered and redundant against a blocklist
+	Verdicts map[string]int
+	// Failed are the inputs we could not read, empty if none
+	Failed string
+	// Report is the name of the saved results in the store, empty if they are not there
+	Report string
+}
+
+// NewSummary counts what is in res, report being the name of the saved results, if any
+func NewSummary(ctx *Context, res *Results, report string) *Summary {
+	d := newReportData(ctx, res)
+	s := &Summary{
+		Program:  MyName + "/" + MyVersion,
+		Files:    res.files,
+		Counts:   d.Counts,
+		Verdicts: map[string]int{},
+		Failed:   d.Failed,
+		Report:   report,
+	}
+	for u, all := range res.Verdicts {
+		for _, v := range all {
+			s.Verdicts[verdictOf(u, v)]++
+		}
+	}
+	return s
+}
+
+// summaryVerdicts are in the order of the summary
+var summaryVerdicts = []string{VerdictBlock, VerdictBlocked, VerdictAuth, VerdictIgnore, "unchecked", DiffListed, DiffCovered, DiffRedundant}
+
+// verdictOf maps what a proxy did with u to the verdicts of the configuration
+func verdictOf(u string, v Verdict) string {
+	switch v.Action {
+	case u:
+		return VerdictBlock
+	case ActionBlocked:
+		return VerdictBlocked
+	case ActionAuth:
+		return VerdictAuth
+	case ActionUnchecked:
+		return "unchecked"
+	case ActionListed:
+		return DiffListed
+	case ActionCovered:
+		return DiffCovered
+	case ActionRedundant:
+		return DiffRedundant
+	}
+	return VerdictIgnore
+}
+
+// Text is the summary in one message
+func (s *Summary) Text() string {
+	counts := []string{}
+	for _, t := range IndicatorTypes {
+		if n := s.Counts[t]; n != 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, t))
+		}
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "nothing to block")
+	}
+
+	verdicts := []string{}
+	for _, v := range summaryVerdicts {
+		if n := s.Verdicts[v]; n != 0 {
+			verdicts = append(verdicts, fmt.Sprintf("%d %s", n, v))
+		}
+	}
+
+	txt := fmt.Sprintf("%s processed %s: %s", s.Program, strings.Join(s.Files, ", "), strings.Join(counts, ", "))
+	if len(verdicts) != 0 {
+		txt += "\nProxy verdicts: " + strings.Join(verdicts, ", ")
+	}
+	if s.Failed != "" {
+		txt += "\nNot read: " + s.Failed
+	}
+	if s.Report != "" {
+		txt += "\nReport: " + s.Report
+	}
+	return txt
+}
+
+// Notifier tells people about a run, it is to a chat what MailSender is to mail
+type Notifier interface {
+	Notify(s *Summary) error
+}
+
+// WebhookNotifier posts to a Slack, Mattermost or Teams incoming webhook
+type WebhookNotifier struct {
+	Client *resty.Client
+	Name   string
+	URL    string
+	Kind   string
+	Link   string
+}
+
+// NewWebhookNotifier checks the configuration
+func NewWebhookNotifier(nc NotifyConfig) (*WebhookNotifier, error) {
+	if nc.URL == "" {
+		return nil, fmt.Errorf("notify %s needs an url", nc.Name)
+	}
+
+	kind := nc.Kind
+	switch kind {
+	case "":
+		kind = NotifySlack
+	case NotifySlack, NotifyMattermost, NotifyTeams:
+	default:
+		return nil, fmt.Errorf("notify %s: unknown kind %s", nc.Name, kind)
+	}
+
+	timeout := nc.Timeout.Duration
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &WebhookNotifier{
+		Client: resty.New().SetTimeout(timeout),
+		Name:   nc.Name,
+		URL:    nc.URL,
+		Kind:   kind,
+		Link:   nc.Link,
+	}, nil
+}
+
+// Notify posts the summary in the format of the webhook
+func (w *WebhookNotifier) Notify(s *Summary) error {
+	sum := *s
+	if w.Link != "" && s.Report != "" && strings.Contains(w.Link, "%s") {
+		sum.Report = fmt.Sprintf(w.Link, s.Report)
+	}
+	txt := sum.Text()
+
+	var payload interface{}
+	if w.Kind == NotifyTeams {
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "http://schema.org/extensions",
+			"summary":  MyName,
+			"text":     strings.Replace(txt, "\n", "\n\n", -1),
+		}
+	} else {
+		payload = map[string]string{"text": txt}
+	}
+
+	resp, err := w.Client.R().SetBody(payload).Post(w.URL)
+	if err != nil {
+		return errors.Wrap(err, w.Name)
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%s: %s", w.Name, resp.Status())
+	}
+	return nil
+}
+
+// Notify sends the summary of res to all the webhooks, report being the name of the saved
+// results in the store, empty if they are not there: it is what the links point to.
+// All are tried, the first error is returned.
+func Notify(ctx *Context, res *Results, report string) error {
+	if len(ctx.notifiers) == 0 {
+		return nil
+	}
+
+	var first error
+	s := NewSummary(ctx, res, report)
+	for _, n := range ctx.notifiers {
+		if err := n.Notify(s); err != nil {
+			log.Printf("notify: %v", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return errors.Wrap(first, "notify")
+}
@@ -0,0 +1,92 @@
+package cimbl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chatServer is a stand-in for an incoming webhook
+func chatServer(t *testing.T, got *[]map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := map[string]string{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		*got = append(*got, body)
+	}))
+}
+
+func TestNewSummary(t *testing.T) {
+	ctx := &Context{config: &Config{}}
+	res := SampleResults()
+	res.AddVerdict("http://example.com/blocked", DefaultProxy, Verdict{Action: ActionBlocked})
+	res.AddVerdict("http://example.com/auth", DefaultProxy, Verdict{Action: ActionAuth})
+	res.AddVerdict("http://example.com/slow", DefaultProxy, Verdict{Action: ActionUnchecked})
+	res.AddVerdict("http://example.com/other", DefaultProxy, Verdict{Action: "http://example.com/"})
+
+	s := NewSummary(ctx, res, "20190701-100000")
+	assert.Equal(t, 1, s.Counts["url"])
+	assert.Equal(t, map[string]int{VerdictBlock: 2, VerdictBlocked: 1, VerdictAuth: 1, VerdictIgnore: 1, "unchecked": 1}, s.Verdicts)
+
+	txt := s.Text()
+	assert.Contains(t, txt, "processed CIMBL-0666-CERTS.csv, CIMBL-0667-CERTS.zip: 1 url, 1 domain, 1 aggregated, 1 network, 1 filename, 1 hash, 1 dns, 1 unchecked, 1 allowed\n")
+	assert.Contains(t, txt, "\nProxy verdicts: 2 block, 1 blocked, 1 auth, 1 ignore, 1 unchecked\n")
+	assert.Contains(t, txt, "\nNot read: CIMBL-0668-CERTS.zip: bad signature")
+	assert.Contains(t, txt, "\nReport: 20190701-100000")
+}
+
+func TestSummary_Empty(t *testing.T) {
+	s := NewSummary(&Context{config: &Config{}}, NewResults(), "")
+	assert.Equal(t, MyName+"/"+MyVersion+" processed : nothing to block", s.Text())
+}
+
+func TestNewWebhookNotifier(t *testing.T) {
+	_, err := NewWebhookNotifier(NotifyConfig{Name: "soc"})
+	assert.Error(t, err)
+
+	_, err = NewWebhookNotifier(NotifyConfig{Name: "soc", URL: "http://localhost", Kind: "irc"})
+	assert.Error(t, err)
+
+	n, err := NewWebhookNotifier(NotifyConfig{Name: "soc", URL: "http://localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, NotifySlack, n.Kind)
+
+	_, err = NewContext(&Config{Notify: []NotifyConfig{{Name: "soc"}}}, Options{})
+	assert.Error(t, err)
+}
+
+func TestNotify(t *testing.T) {
+	got := []map[string]string{}
+	ts := chatServer(t, &got)
+	defer ts.Close()
+
+	config := &Config{Notify: []NotifyConfig{
+		{Name: "slack", URL: ts.URL + "/slack", Link: "https://intranet.example.com/cimbl/%s"},
+		{Name: "broken", URL: ts.URL + "/broken"},
+		{Name: "teams", URL: ts.URL + "/teams", Kind: NotifyTeams},
+	}}
+	ctx, err := NewContext(config, Options{})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	err = Notify(ctx, SampleResults(), "20190701-100000")
+	assert.Error(t, err)
+
+	// All tried despite the error
+	require.Len(t, got, 2)
+	assert.Contains(t, got[0]["text"], "\nReport: https://intranet.example.com/cimbl/20190701-100000")
+	assert.Equal(t, "MessageCard", got[1]["@type"])
+	assert.Contains(t, got[1]["text"], "\n\nReport: 20190701-100000")
+
+	got = got[:0]
+	ctx.SetNotifiers()
+	assert.NoError(t, Notify(ctx, SampleResults(), ""))
+	assert.Empty(t, got)
+}
@@ -0,0 +1,148 @@
+package cimbl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Report orderings, entries of each section are sorted by host then value by default
+const (
+	OrderHost   = "host"
+	OrderDomain = "domain"
+	OrderThreat = "threat"
+)
+
+// checkOrder refuses unknown orderings, "" is the default
+func checkOrder(order string) error {
+	switch order {
+	case "", OrderHost, OrderDomain, OrderThreat:
+		return nil
+	}
+	return fmt.Errorf("unknown order %s, use %s, %s or %s", order, OrderHost, OrderDomain, OrderThreat)
+}
+
+// sortKey is what an entry is sorted on
+type sortKey struct {
+	threat int
+	domain string
+	host   string
+	value  string
+}
+
+// hostDomain returns the host and registered domain of an URL or host, str itself if it is neither
+func hostDomain(str string) (string, string) {
+	u := str
+	if !strings.Contains(u, "://") {
+		u = "http://" + u
+	}
+	c, err := Canonicalize(u)
+	if err != nil {
+		return str, str
+	}
+	return c.Host, c.Domain
+}
+
+// threatLevels are the values of indicator_threat_level in the CIMBL files, worst last
+var threatLevels = []string{"low", "medium", "high", "critical"}
+
+// threatRank orders the threat levels, 0 is unknown
+func threatRank(level string) int {
+	level = strings.ToLower(strings.TrimSpace(level))
+	for i, l := range threatLevels {
+		if l == level {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// threat is the highest CIMBL threat level of an entry, the one of its URLs for an aggregated
+// or https domain
+func (r *Results) threat(str string) int {
+	n := threatRank(r.Threats[str])
+	for _, u := range r.Aggregated[str] {
+		if t := threatRank(r.Threats[u]); t > n {
+			n = t
+		}
+	}
+	for u, level := range r.Threats {
+		if host, ok := httpsHost(u); ok && host == str {
+			if t := threatRank(level); t > n {
+				n = t
+			}
+		}
+	}
+	return n
+}
+
+// sorted puts the entries of a section in the order of the report
+func (ctx *Context) sorted(res *Results, all []string) []string {
+	order := ctx.opts.Order
+	if order == "" {
+		order = OrderHost
+	}
+
+	keys := map[string]sortKey{}
+	for _, v := range all {
+		k := sortKey{value: v}
+		k.host, k.domain = hostDomain(v)
+		if order == OrderThreat {
+			k.threat = res.threat(v)
+		}
+		keys[v] = k
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := keys[all[i]], keys[all[j]]
+		if a.threat != b.threat {
+			return a.threat > b.threat
+		}
+		if order == OrderDomain && a.domain != b.domain {
+			return a.domain < b.domain
+		}
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		return a.value < b.value
+	})
+	return all
+}
+
+// aggregatedKeys returns the aggregated domains
+func aggregatedKeys(res *Results) []string {
+	keys := []string{}
+	for k := range res.Aggregated {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// allowedKeys returns the entries protected by the allowlist
+func allowedKeys(res *Results) []string {
+	keys := []string{}
+	for k := range res.Allowed {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// keysOf returns the keys of all, to be sorted
+func keysOf(all map[string]bool) []string {
+	keys := []string{}
+	for k := range all {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// resolving returns the hosts the DNS firewall does not block
+func resolving(res *Results) []string {
+	hosts := []string{}
+	for k, v := range res.DNS {
+		if v == DNSResolves {
+			hosts = append(hosts, k)
+		}
+	}
+	return hosts
+}
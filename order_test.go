@@ -0,0 +1,81 @@
+package cimbl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOrder(t *testing.T) {
+	for _, o := range []string{"", OrderHost, OrderDomain, OrderThreat} {
+		assert.NoError(t, checkOrder(o), o)
+	}
+	assert.Error(t, checkOrder("random"))
+}
+
+func TestNewContextOrder(t *testing.T) {
+	ctx, err := NewContext(&Config{Order: OrderThreat}, Options{})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+	assert.Equal(t, OrderThreat, ctx.Options().Order)
+
+	_, err = NewContext(&Config{}, Options{Order: "random"})
+	assert.Error(t, err)
+}
+
+func TestHostDomain(t *testing.T) {
+	host, domain := hostDomain("http://www.Example.co.uk/malware")
+	assert.Equal(t, "www.example.co.uk", host)
+	assert.Equal(t, "example.co.uk", domain)
+
+	host, domain = hostDomain("www.example.com")
+	assert.Equal(t, "www.example.com", host)
+	assert.Equal(t, "example.com", domain)
+
+	host, domain = hostDomain("http://[::1")
+	assert.Equal(t, "http://[::1", host)
+	assert.Equal(t, "http://[::1", domain)
+}
+
+func TestContext_Sorted(t *testing.T) {
+	all := []string{
+		"https://b.example.net/x",
+		"http://z.example.com/b",
+		"http://a.example.net/y",
+		"http://z.example.com/a",
+		"http://a.example.com/z",
+	}
+	res := NewResults()
+	res.AddThreat("http://a.example.net/y", "Critical")
+	res.AddThreat("http://z.example.com/b", "Medium")
+	res.AddThreat("http://z.example.com/b", "Low")
+	res.AddThreat("http://z.example.com/a", "unknown")
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"", []string{"http://a.example.com/z", "http://a.example.net/y", "https://b.example.net/x", "http://z.example.com/a", "http://z.example.com/b"}},
+		{OrderDomain, []string{"http://a.example.com/z", "http://z.example.com/a", "http://z.example.com/b", "http://a.example.net/y", "https://b.example.net/x"}},
+		{OrderThreat, []string{"http://a.example.net/y", "http://z.example.com/b", "http://a.example.com/z", "https://b.example.net/x", "http://z.example.com/a"}},
+	}
+	for _, tt := range tests {
+		ctx := &Context{opts: Options{Order: tt.order}}
+		assert.Equal(t, tt.want, ctx.sorted(res, append([]string{}, all...)), tt.order)
+	}
+}
+
+func TestContext_SortedAggregated(t *testing.T) {
+	res := NewResults()
+	res.AddAggregated("example.com", "http://www.example.com/a")
+	res.AddAggregated("example.net", "http://www.example.net/a", "http://www.example.net/b")
+	res.AddThreat("http://www.example.net/b", "High")
+
+	ctx := &Context{opts: Options{Order: OrderThreat}}
+	assert.Equal(t, []string{"example.net", "example.com"}, ctx.sorted(res, aggregatedKeys(res)))
+
+	// An https URL is blocked at the domain level
+	res.AddThreat("https://www.example.com/login", "critical")
+	assert.Equal(t, []string{"www.example.com", "example.net"}, ctx.sorted(res, []string{"example.net", "www.example.com"}))
+}
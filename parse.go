@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"bytes"
@@ -140,12 +140,20 @@ We filter on "type", looking for "url" & "filename".
 
 */
 
-// handleAllFiles processes a list of files
-func handleAllFiles(ctx *Context, files []string) (*Results, error) {
+// CheckFiles reads and checks a list of files, bad inputs are fatal only in strict mode,
+// otherwise they are kept in the results.
+func CheckFiles(ctx *Context, files []string) (*Results, error) {
 	// For all files on the CLI
 	//res := NewResults()
+	t0 := time.Now()
 
-	list := NewList(files)
+	list, err := LoadList(files, ctx.opts)
+	if err != nil {
+		if ctx.opts.Strict {
+			return nil, errors.Wrap(err, "strict")
+		}
+		log.Printf("%v", err)
+	}
 	debug("list=%#v\n", list)
 
 	if list.Length() != 0 {
@@ -154,8 +162,31 @@ func handleAllFiles(ctx *Context, files []string) (*Results, error) {
 		t2 := time.Since(t1)
 		verbose("time=%v", t2)
 		debug("r(main)=%#v\n", r)
+		r.failed = err
+		r.Meta = ctx.metadata(files, t0)
+		list.withOrigins(r)
+		if ctx.blocklist != nil {
+			r.markRedundant(ctx.blocklist.Name)
+		}
 		return r, nil
 	}
 	log.Printf("Empty list.")
-	return NewResults(), nil
+	r := NewResults()
+	r.failed = err
+	r.Meta = ctx.metadata(files, t0)
+	return r, nil
+}
+
+// metadata describes a run started at t0
+func (ctx *Context) metadata(files []string, t0 time.Time) Metadata {
+	m := Metadata{
+		Program:  MyName + "/" + MyVersion,
+		Started:  t0,
+		Duration: time.Since(t0),
+		Inputs:   files,
+	}
+	for _, p := range ctx.Proxies() {
+		m.Proxies = append(m.Proxies, p.Name)
+	}
+	return m
 }
@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"fmt"
@@ -44,8 +44,7 @@ func TestExtractZipOne(t *testing.T) {
 }
 
 func TestExtractZipZipin(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	snd, err := sandbox.New("test")
@@ -55,7 +54,7 @@ func TestExtractZipZipin(t *testing.T) {
 	ctx := &Context{
 		config:  config,
 		tempdir: snd,
-		jobs:    1,
+		opts:    Options{Jobs: 1},
 	}
 
 	var base string
@@ -124,8 +123,7 @@ func TestReadIPList_Good(t *testing.T) {
 }
 
 func TestHandleAllFiles_None(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	require.NotEmpty(t, config.REFile)
 
@@ -136,17 +134,16 @@ func TestHandleAllFiles_None(t *testing.T) {
 	ctx := &Context{
 		config:  config,
 		tempdir: snd,
-		jobs:    1,
+		opts:    Options{Jobs: 1},
 	}
 
-	res, err := handleAllFiles(ctx, nil)
+	res, err := CheckFiles(ctx, nil)
 	assert.NoError(t, err)
 	assert.Empty(t, res.URLs)
 }
 
 func TestHandleAllFiles_Null(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	require.NotEmpty(t, config.REFile)
 
@@ -157,17 +154,22 @@ func TestHandleAllFiles_Null(t *testing.T) {
 	ctx := &Context{
 		config:  config,
 		tempdir: snd,
-		jobs:    1,
+		opts:    Options{Jobs: 1},
 	}
 
-	res, err := handleAllFiles(ctx, []string{"/nonexistent"})
+	res, err := CheckFiles(ctx, []string{"/nonexistent"})
 	assert.NoError(t, err)
 	assert.Empty(t, res.URLs)
+	assert.Error(t, res.failed)
+
+	ctx.opts.Strict = true
+	res, err = CheckFiles(ctx, []string{"/nonexistent"})
+	assert.Error(t, err)
+	assert.Nil(t, res)
 }
 
 func TestHandleAllFiles_SingleBad(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	require.NotEmpty(t, config.REFile)
 
@@ -178,17 +180,16 @@ func TestHandleAllFiles_SingleBad(t *testing.T) {
 	ctx := &Context{
 		config:  config,
 		tempdir: snd,
-		jobs:    1,
+		opts:    Options{Jobs: 1},
 	}
 
-	res, err := handleAllFiles(ctx, []string{"testdata/CIMBL-0667-CERTS.csv"})
+	res, err := CheckFiles(ctx, []string{"testdata/CIMBL-0667-CERTS.csv"})
 	assert.NoError(t, err)
 	assert.Empty(t, res.URLs)
 }
 
 func TestHandleAllFiles_SingleBad2(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	require.NotEmpty(t, config.REFile)
 
@@ -196,25 +197,24 @@ func TestHandleAllFiles_SingleBad2(t *testing.T) {
 	require.NoError(t, err)
 	defer snd.Cleanup()
 
-	ctx := &Context{config: config, tempdir: snd, jobs: 1}
+	ctx := &Context{config: config, tempdir: snd, opts: Options{Jobs: 1}}
 
 	c := resty.New()
 	ctx.Client = c
 
-	fDebug = true
-	res, err := handleAllFiles(ctx, []string{"http://localhost/foo.php"})
+	SetDebug(true)
+	res, err := CheckFiles(ctx, []string{"http://localhost/foo.php"})
 	t.Logf("res/test=%#v", res)
 	assert.NoError(t, err)
 	assert.Empty(t, res.URLs)
-	fDebug = false
+	SetDebug(false)
 }
 
 func TestHandleAllFiles_OneFile(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
-	fVerbose = true
+	SetVerbose(true)
 
 	realPaths := map[string]bool{
 		"55fe62947f3860108e7798c4498618cb.rtf": true,
@@ -224,7 +224,7 @@ func TestHandleAllFiles_OneFile(t *testing.T) {
 		TestSite: true,
 	}
 
-	ctx := &Context{config: config, jobs: 1}
+	ctx := &Context{config: config, opts: Options{Jobs: 1}}
 
 	c := resty.New()
 
@@ -242,7 +242,7 @@ func TestHandleAllFiles_OneFile(t *testing.T) {
 
 	file := "testdata/CIMBL-0666-CERTS.csv"
 
-	res, err := handleAllFiles(ctx, []string{file})
+	res, err := CheckFiles(ctx, []string{file})
 	assert.NoError(t, err)
 
 	assert.NotEmpty(t, res.Paths)
@@ -252,11 +252,10 @@ func TestHandleAllFiles_OneFile(t *testing.T) {
 }
 
 func TestHandleAllFiles_OneFile1(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
-	fDebug = true
+	SetDebug(true)
 
 	realPaths := map[string]bool{
 		"55fe62947f3860108e7798c4498618cb.rtf": true,
@@ -266,7 +265,7 @@ func TestHandleAllFiles_OneFile1(t *testing.T) {
 		TestSite: true,
 	}
 
-	ctx := &Context{config: config, jobs: 1}
+	ctx := &Context{config: config, opts: Options{Jobs: 1}}
 
 	c := resty.New()
 
@@ -284,7 +283,7 @@ func TestHandleAllFiles_OneFile1(t *testing.T) {
 
 	file := "testdata/CIMBL-0666-CERTS.csv"
 
-	res, err := handleAllFiles(ctx, []string{file})
+	res, err := CheckFiles(ctx, []string{file})
 	assert.NoError(t, err)
 
 	assert.NotEmpty(t, res.Paths)
@@ -292,15 +291,14 @@ func TestHandleAllFiles_OneFile1(t *testing.T) {
 	assert.EqualValues(t, realPaths, res.Paths)
 	assert.EqualValues(t, realURLs, res.URLs)
 
-	fDebug = false
+	SetDebug(false)
 }
 
 func TestHandleAllFiles_OneURL(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
-	fDebug = true
+	SetDebug(true)
 
 	realURLs := map[string]bool{
 		TestSite: true,
@@ -313,7 +311,7 @@ func TestHandleAllFiles_OneURL(t *testing.T) {
 	ctx := &Context{
 		config:  config,
 		tempdir: snd,
-		jobs:    1,
+		opts:    Options{Jobs: 1},
 	}
 
 	c := resty.New()
@@ -332,14 +330,14 @@ func TestHandleAllFiles_OneURL(t *testing.T) {
 
 	file := TestSite
 
-	res, err := handleAllFiles(ctx, []string{file})
+	res, err := CheckFiles(ctx, []string{file})
 	assert.NoError(t, err)
 
 	assert.Empty(t, res.Paths)
 	assert.NotEmpty(t, res.URLs)
 	assert.EqualValues(t, realURLs, res.URLs)
 
-	fDebug = false
+	SetDebug(false)
 }
 
 func TestRemoveExt(t *testing.T) {
@@ -0,0 +1,283 @@
+package cimbl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PatchConfig is the blocklist kept in a git working tree, new entries are appended and
+// committed there, pushing is left to humans
+//
+// [patch]
+// repo = "/srv/firewall-config"
+// file = "proxy/cimbl.txt"
+// format = "text"
+// author = "erc-cimbl <cimbl@example.com>"
+type PatchConfig struct {
+	Repo string
+	// File is relative to Repo
+	File string
+	// Format is text, squid or edl, guessed from the extension if empty
+	Format string
+	// Author of the commit, the git configuration if empty
+	Author string
+}
+
+// Patch is what was done to the blocklist
+type Patch struct {
+	// Added are the lines appended
+	Added []string
+	// Skipped are the entries the blocklist already has
+	Skipped []string
+	// Unwritable are the entries the format can not have, like URLs in a Squid list
+	Unwritable []string
+	// Commit is the hash of the commit, empty if nothing was added
+	Commit string
+}
+
+// patchTypes are the types we append, broader ones first so that they cover the others
+var patchTypes = []string{"aggregated", "network", "domain", "url"}
+
+// patchLine is the blocklist line of an entry in format, empty if it can not be written
+func patchLine(format, typ, value string) string {
+	switch typ {
+	case "network":
+		return value
+	case "aggregated":
+		switch format {
+		case BlocklistSquid:
+			return "." + value
+		case BlocklistEDL:
+			return "*." + value
+		}
+		return value
+	case "domain":
+		return value
+	case "url":
+		switch format {
+		case BlocklistSquid:
+			return ""
+		case BlocklistEDL:
+			key, _, ok := urlKey(value)
+			if !ok {
+				return ""
+			}
+			return key
+		}
+		return value
+	}
+	return ""
+}
+
+// patchEntries returns the entries of res per type, in the patch order
+func patchEntries(ctx *Context, res *Results) map[string][]string {
+	return map[string][]string{
+		"aggregated": ctx.sorted(res, aggregatedKeys(res)),
+		"network":    ctx.sorted(res, keysOf(res.Networks)),
+		"domain":     ctx.sorted(res, keysOf(res.Domains)),
+		"url":        ctx.sorted(res, keysOf(res.URLs)),
+	}
+}
+
+// originsOf returns where an entry comes from, the URLs of an aggregated or https domain included
+func originsOf(res *Results, typ, value string) []Origin {
+	all := append([]Origin{}, res.Origins[value]...)
+	if typ == "aggregated" {
+		for _, u := range res.Aggregated[value] {
+			all = append(all, res.Origins[u]...)
+		}
+	}
+	if typ == "domain" {
+		for u, o := range res.Origins {
+			if host, ok := httpsHost(u); ok && host == value {
+				all = append(all, o...)
+			}
+		}
+	}
+
+	seen := map[Origin]bool{}
+	uniq := []Origin{}
+	for _, o := range all {
+		if !seen[o] {
+			seen[o] = true
+			uniq = append(uniq, o)
+		}
+	}
+	sort.Slice(uniq, func(i, j int) bool {
+		if uniq[i].File != uniq[j].File {
+			return uniq[i].File < uniq[j].File
+		}
+		return uniq[i].UUID < uniq[j].UUID
+	})
+	return uniq
+}
+
+// patchComment cites the CIMBL files and indicators of an entry, the files of the run if unknown
+func patchComment(res *Results, typ, value string) string {
+	all := []string{}
+	for _, o := range originsOf(res, typ, value) {
+		all = append(all, strings.TrimSpace(o.File+" "+o.UUID))
+	}
+	if len(all) == 0 {
+		all = append(all, patchSources(res))
+	}
+	return "# " + strings.Join(all, ", ")
+}
+
+// patchSources are the CIMBL files of res, the inputs of the run for IP lists and others
+func patchSources(res *Results) string {
+	if len(res.files) != 0 {
+		return strings.Join(res.files, ", ")
+	}
+	names := []string{}
+	for _, in := range res.Meta.Inputs {
+		names = append(names, filepath.Base(in))
+	}
+	if len(names) == 0 {
+		return "unknown inputs"
+	}
+	return strings.Join(names, ", ")
+}
+
+// git runs a git command in repo and returns its output
+func git(repo string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// WritePatch appends the entries of res the blocklist does not have yet, each one with
+// a comment citing its CIMBL file and indicator, then commits the file.  Nothing is pushed.
+func WritePatch(ctx *Context, res *Results) (*Patch, error) {
+	pc := ctx.config.Patch
+	if pc == nil || pc.Repo == "" || pc.File == "" {
+		return nil, fmt.Errorf("no blocklist repository configured")
+	}
+	format := pc.Format
+	if format == "" {
+		format = guessBlocklist(pc.File)
+	}
+	if format == BlocklistCPL || blocklistParsers[format] == nil {
+		return nil, fmt.Errorf("can not append to a %s blocklist", format)
+	}
+
+	if _, err := git(pc.Repo, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, errors.Wrap(err, "patch")
+	}
+	// We do not want to commit someone else's changes
+	status, err := git(pc.Repo, "status", "--porcelain", "--", pc.File)
+	if err != nil {
+		return nil, errors.Wrap(err, "patch")
+	}
+	if status != "" {
+		return nil, fmt.Errorf("patch: %s has local changes", pc.File)
+	}
+
+	file := filepath.Join(pc.Repo, pc.File)
+	old, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "patch")
+	}
+	current := NewBlocklist("")
+	if err := current.Read(bytes.NewReader(old), format); err != nil {
+		return nil, errors.Wrap(err, "patch")
+	}
+
+	p := &Patch{}
+	counts := map[string]int{}
+	var buf bytes.Buffer
+	entries := patchEntries(ctx, res)
+	for _, typ := range patchTypes {
+		for _, e := range entries[typ] {
+			vtyp := typ
+			if typ == "aggregated" {
+				vtyp = "domain"
+			}
+			if v := current.Verdict(vtyp, e); v.Action != e {
+				p.Skipped = append(p.Skipped, e)
+				continue
+			}
+			line := patchLine(format, typ, e)
+			if line == "" {
+				verbose("patch: %s can not go into a %s blocklist", e, format)
+				p.Unwritable = append(p.Unwritable, e)
+				continue
+			}
+
+			// Later entries are checked against this one too
+			for _, ent := range blocklistParsers[format](line) {
+				current.add(ent)
+			}
+			fmt.Fprintf(&buf, "%s\n%s\n", patchComment(res, typ, e), line)
+			p.Added = append(p.Added, line)
+			counts[typ]++
+		}
+	}
+	if len(p.Added) == 0 {
+		return p, nil
+	}
+
+	started := res.Meta.Started
+	if started.IsZero() {
+		started = time.Now()
+	}
+	var out bytes.Buffer
+	out.Write(old)
+	if len(old) != 0 && old[len(old)-1] != '\n' {
+		out.WriteString("\n")
+	}
+	fmt.Fprintf(&out, "# %s/%s %s: %s\n", MyName, MyVersion, started.Format("2006-01-02"), patchSources(res))
+	out.Write(buf.Bytes())
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, errors.Wrap(err, "patch")
+	}
+	if err := ioutil.WriteFile(file, out.Bytes(), 0644); err != nil {
+		return nil, errors.Wrap(err, "patch")
+	}
+
+	if _, err := git(pc.Repo, "add", "--", pc.File); err != nil {
+		return nil, errors.Wrap(err, "patch")
+	}
+	args := []string{"commit", "-q", "-m", patchMessage(res, pc.File, counts, len(p.Skipped))}
+	if pc.Author != "" {
+		args = append(args, "--author", pc.Author)
+	}
+	if _, err := git(pc.Repo, append(args, "--", pc.File)...); err != nil {
+		return nil, errors.Wrap(err, "patch")
+	}
+	p.Commit, err = git(pc.Repo, "rev-parse", "HEAD")
+	return p, errors.Wrap(err, "patch")
+}
+
+// patchMessage describes the commit
+func patchMessage(res *Results, file string, counts map[string]int, skipped int) string {
+	total := 0
+	all := []string{}
+	for _, typ := range patchTypes {
+		if n := counts[typ]; n != 0 {
+			total += n
+			all = append(all, fmt.Sprintf("%d %s", n, typ))
+		}
+	}
+
+	msg := fmt.Sprintf("Block %d indicators from %s\n\nAdded to %s: %s.\n", total, patchSources(res), file, strings.Join(all, ", "))
+	if skipped != 0 {
+		msg += fmt.Sprintf("Already blocked: %d.\n", skipped)
+	}
+	return msg + fmt.Sprintf("\nGenerated by %s/%s.\n", MyName, MyVersion)
+}
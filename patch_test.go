@@ -0,0 +1,189 @@
+package cimbl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gitRepo creates a repository with file committed in it
+func gitRepo(t *testing.T, file, content string) string {
+	dir, err := ioutil.TempDir("", "cimbl-git")
+	require.NoError(t, err)
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "Test"},
+		{"config", "user.email", "test@example.com"},
+	} {
+		_, err := git(dir, args...)
+		require.NoError(t, err)
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+	_, err = git(dir, "add", file)
+	require.NoError(t, err)
+	_, err = git(dir, "commit", "-q", "-m", "Initial blocklist")
+	require.NoError(t, err)
+	return dir
+}
+
+// patchResults has one entry of every type, some already in the blocklists of the tests
+func patchResults() *Results {
+	res := NewResults()
+	res.files = []string{"CIMBL-0700-CERTS.csv"}
+	res.Meta.Started = time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	res.Add("url", "http://www.example.com/malware.php")
+	res.Add("url", "http://evil.example.org/kit/")
+	res.Add("url", "http://bad.example.net/drop.exe")
+	res.Add("domain", "evil.example.net")
+	res.Add("network", "203.0.113.0/24")
+	res.AddAggregated("example.net", "http://a.example.net/1", "http://b.example.net/2")
+	res.AddOrigin("http://www.example.com/malware.php", Origin{File: "CIMBL-0700-CERTS.csv", UUID: "certeu:Indicator-1"})
+	res.AddOrigin("https://evil.example.net/login", Origin{File: "CIMBL-0700-CERTS.csv", UUID: "certeu:Indicator-2"})
+	res.AddOrigin("http://a.example.net/1", Origin{File: "CIMBL-0700-CERTS.csv", UUID: "certeu:Indicator-3"})
+	return res
+}
+
+func TestPatchLine(t *testing.T) {
+	tests := []struct {
+		format, typ, value string
+		want               string
+	}{
+		{BlocklistText, "url", "http://www.example.com/a", "http://www.example.com/a"},
+		{BlocklistText, "aggregated", "example.com", "example.com"},
+		{BlocklistSquid, "url", "http://www.example.com/a", ""},
+		{BlocklistSquid, "domain", "www.example.com", "www.example.com"},
+		{BlocklistSquid, "aggregated", "example.com", ".example.com"},
+		{BlocklistEDL, "url", "http://www.example.com/a", "www.example.com/a"},
+		{BlocklistEDL, "aggregated", "example.com", "*.example.com"},
+		{BlocklistEDL, "network", "10.0.0.0/8", "10.0.0.0/8"},
+		{BlocklistEDL, "hash", "55fe62947f3860108e7798c4498618cb", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, patchLine(tt.format, tt.typ, tt.value), tt.format+"/"+tt.value)
+	}
+}
+
+func TestOriginsOf(t *testing.T) {
+	res := patchResults()
+	assert.Equal(t, []Origin{{"CIMBL-0700-CERTS.csv", "certeu:Indicator-1"}}, originsOf(res, "url", "http://www.example.com/malware.php"))
+	assert.Equal(t, []Origin{{"CIMBL-0700-CERTS.csv", "certeu:Indicator-2"}}, originsOf(res, "domain", "evil.example.net"))
+	assert.Equal(t, []Origin{{"CIMBL-0700-CERTS.csv", "certeu:Indicator-3"}}, originsOf(res, "aggregated", "example.net"))
+	assert.Empty(t, originsOf(res, "network", "203.0.113.0/24"))
+
+	assert.Equal(t, "# CIMBL-0700-CERTS.csv", patchComment(res, "network", "203.0.113.0/24"))
+}
+
+func TestWritePatch(t *testing.T) {
+	repo := gitRepo(t, "cimbl.txt", "# managed blocklist\n203.0.113.0/24\nevil.example.org")
+	defer os.RemoveAll(repo)
+
+	ctx := &Context{config: &Config{Patch: &PatchConfig{Repo: repo, File: "cimbl.txt", Author: "CIMBL <cimbl@example.com>"}}}
+	p, err := WritePatch(ctx, patchResults())
+	require.NoError(t, err)
+	assert.NotEmpty(t, p.Commit)
+	assert.Equal(t, []string{"example.net", "http://www.example.com/malware.php"}, p.Added)
+	// The new domain covers evil.example.net and its URLs
+	assert.ElementsMatch(t, []string{"203.0.113.0/24", "evil.example.net", "http://evil.example.org/kit/", "http://bad.example.net/drop.exe"}, p.Skipped)
+
+	buf, err := ioutil.ReadFile(filepath.Join(repo, "cimbl.txt"))
+	require.NoError(t, err)
+	want := `# managed blocklist
+203.0.113.0/24
+evil.example.org
+# erc-cimbl/` + MyVersion + ` 2019-07-01: CIMBL-0700-CERTS.csv
+# CIMBL-0700-CERTS.csv certeu:Indicator-3
+example.net
+# CIMBL-0700-CERTS.csv certeu:Indicator-1
+http://www.example.com/malware.php
+`
+	assert.Equal(t, want, string(buf))
+
+	msg, err := git(repo, "log", "-1", "--format=%an%n%B")
+	require.NoError(t, err)
+	assert.Contains(t, msg, "CIMBL\nBlock 2 indicators from CIMBL-0700-CERTS.csv")
+	assert.Contains(t, msg, "Added to cimbl.txt: 1 aggregated, 1 url.")
+	assert.Contains(t, msg, "Already blocked: 4.")
+
+	// Nothing new the second time
+	p, err = WritePatch(ctx, patchResults())
+	require.NoError(t, err)
+	assert.Empty(t, p.Added)
+	assert.Empty(t, p.Commit)
+}
+
+func TestWritePatch_EDL(t *testing.T) {
+	repo := gitRepo(t, "urls.edl", "*.example.org\n")
+	defer os.RemoveAll(repo)
+
+	ctx := &Context{config: &Config{Patch: &PatchConfig{Repo: repo, File: "urls.edl"}}}
+	p, err := WritePatch(ctx, patchResults())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.example.net", "203.0.113.0/24", "www.example.com/malware.php"}, p.Added)
+}
+
+func TestWritePatch_Squid(t *testing.T) {
+	repo := gitRepo(t, "cimbl.acl", ".example.org\n")
+	defer os.RemoveAll(repo)
+
+	ctx := &Context{config: &Config{Patch: &PatchConfig{Repo: repo, File: "cimbl.acl"}}}
+	p, err := WritePatch(ctx, patchResults())
+	require.NoError(t, err)
+	assert.Equal(t, []string{".example.net", "203.0.113.0/24"}, p.Added)
+	// No URLs in a Squid list
+	assert.Equal(t, []string{"http://www.example.com/malware.php"}, p.Unwritable)
+}
+
+func TestWritePatch_IPList(t *testing.T) {
+	repo := gitRepo(t, "cimbl.txt", "# managed blocklist\n")
+	defer os.RemoveAll(repo)
+
+	res := NewResults()
+	res.Meta.Started = time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	res.Meta.Inputs = []string{"/var/tmp/bad-ips.txt"}
+	res.Add("network", "203.0.113.0/24")
+
+	ctx := &Context{config: &Config{Patch: &PatchConfig{Repo: repo, File: "cimbl.txt"}}}
+	_, err := WritePatch(ctx, res)
+	require.NoError(t, err)
+
+	buf, err := ioutil.ReadFile(filepath.Join(repo, "cimbl.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "# managed blocklist\n# erc-cimbl/"+MyVersion+" 2019-07-01: bad-ips.txt\n# bad-ips.txt\n203.0.113.0/24\n", string(buf))
+
+	msg, err := git(repo, "log", "-1", "--format=%B")
+	require.NoError(t, err)
+	assert.Contains(t, msg, "Block 1 indicators from bad-ips.txt\n")
+}
+
+func TestWritePatch_Errors(t *testing.T) {
+	_, err := WritePatch(&Context{config: &Config{}}, patchResults())
+	assert.Error(t, err)
+
+	repo := gitRepo(t, "cimbl.txt", "example.org\n")
+	defer os.RemoveAll(repo)
+
+	// Not for CPL
+	ctx := &Context{config: &Config{Patch: &PatchConfig{Repo: repo, File: "cimbl.txt", Format: BlocklistCPL}}}
+	_, err = WritePatch(ctx, patchResults())
+	assert.Error(t, err)
+
+	// Not a repository
+	dir, err := ioutil.TempDir("", "cimbl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ctx = &Context{config: &Config{Patch: &PatchConfig{Repo: dir, File: "cimbl.txt"}}}
+	_, err = WritePatch(ctx, patchResults())
+	assert.Error(t, err)
+
+	// Someone is editing it
+	require.NoError(t, ioutil.WriteFile(filepath.Join(repo, "cimbl.txt"), []byte("example.org\nexample.com\n"), 0644))
+	ctx = &Context{config: &Config{Patch: &PatchConfig{Repo: repo, File: "cimbl.txt"}}}
+	_, err = WritePatch(ctx, patchResults())
+	assert.Error(t, err)
+}
@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"errors"
@@ -35,7 +35,7 @@ func init() {
 }
 
 func handlePath(ctx *Context, str string) (string, error) {
-	if fNoPaths {
+	if ctx.opts.NoPaths {
 		return "", nil
 	}
 	path := entryToPath(str)
@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"testing"
@@ -21,9 +21,7 @@ func TestHandlePath(t *testing.T) {
 }
 
 func TestHandlePathno(t *testing.T) {
-	ctx := &Context{}
-
-	fNoPaths = true
+	ctx := &Context{opts: Options{NoPaths: true}}
 
 	path1 := "foo.exe"
 	r, err := handlePath(ctx, path1)
@@ -34,13 +32,11 @@ func TestHandlePathno(t *testing.T) {
 	r, err = handlePath(ctx, path2)
 	assert.Empty(t, r)
 	assert.NoError(t, err)
-
-	fNoPaths = false
 }
 
 func TestHandlePathVerbose(t *testing.T) {
 	ctx := &Context{}
-	fVerbose = true
+	SetVerbose(true)
 
 	path1 := "foo.exe"
 	r, err := handlePath(ctx, path1)
@@ -51,7 +47,7 @@ func TestHandlePathVerbose(t *testing.T) {
 	r, err = handlePath(ctx, path2)
 	assert.NotEmpty(t, r)
 	assert.NoError(t, err)
-	fVerbose = false
+	SetVerbose(false)
 }
 
 func TestEntryToPath(t *testing.T) {
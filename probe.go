@@ -0,0 +1,167 @@
+package cimbl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	// DefaultLimit is how much of the body we read with GET
+	DefaultLimit = 512
+)
+
+var (
+	// DefaultMethods is HEAD then a ranged GET
+	DefaultMethods = []string{http.MethodHead, http.MethodGet}
+
+	// DefaultFallback are the codes meaning the method was rejected
+	DefaultFallback = []int{http.StatusMethodNotAllowed, http.StatusNotImplemented}
+
+	// defaultProber is used when the context has none
+	defaultProber, _ = NewProber(nil, 0, nil)
+)
+
+// Prober tries each method in turn as long as the answer is inconclusive
+type Prober struct {
+	Methods  []string
+	Limit    int64
+	Fallback map[int]bool
+}
+
+// NewProber checks the strategy and fills in the defaults
+func NewProber(methods []string, limit int64, fallback []int) (*Prober, error) {
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if len(fallback) == 0 {
+		fallback = DefaultFallback
+	}
+
+	p := &Prober{
+		Limit:    limit,
+		Fallback: map[int]bool{},
+	}
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		if m != http.MethodHead && m != http.MethodGet {
+			return nil, fmt.Errorf("unsupported probe method %s", m)
+		}
+		p.Methods = append(p.Methods, m)
+	}
+	for _, c := range fallback {
+		p.Fallback[c] = true
+	}
+	return p, nil
+}
+
+// Probe checks str with each method until we get a real answer, within the limits of
+// the context
+func (p *Prober) Probe(ctx *Context, c *resty.Client, str string) (answer, error) {
+	var (
+		a   answer
+		err error
+	)
+
+	run := ctx.Run()
+	for _, m := range p.Methods {
+		method := m
+		a, err = ctx.throttle.Do(run, hostOf(str), func() (answer, error) {
+			return p.probe1(run, ctx.auth, c, method, str)
+		})
+		if run.Err() != nil {
+			return a, run.Err()
+		}
+		if err == nil && !p.Fallback[a.Code] {
+			return a, nil
+		}
+		debug("%s %s inconclusive: %d %v", method, str, a.Code, err)
+	}
+	return a, err
+}
+
+// probe1 does one request, authenticating with pa if asked to.  NTLM and Negotiate
+// authenticate the connection, their legs go through a client of their own.
+func (p *Prober) probe1(ctx context.Context, pa Authenticator, c *resty.Client, method, str string) (answer, error) {
+	var hc *resty.Client
+	defer func() {
+		if hc != nil && hc != c {
+			hc.GetClient().CloseIdleConnections()
+		}
+	}()
+
+	return authenticate(pa, func(auth string) (answer, error) {
+		if auth == "" || pa.Scheme() == AuthBasic {
+			return p.request(ctx, c, method, str, auth)
+		}
+		if hc == nil {
+			hc = handshakeClient(c)
+		}
+		return p.request(ctx, hc, method, str, auth)
+	})
+}
+
+// handshakeClient is c with only one connection, so that all legs use it
+func handshakeClient(c *resty.Client) *resty.Client {
+	hc := *c.GetClient()
+	tr, ok := hc.Transport.(*http.Transport)
+	if !ok {
+		debug("handshake: unknown transport %T", hc.Transport)
+		return c
+	}
+	tr = tr.Clone()
+	tr.DisableKeepAlives = false
+	tr.MaxConnsPerHost = 1
+	tr.MaxIdleConnsPerHost = 1
+	hc.Transport = tr
+	return resty.NewWithClient(&hc)
+}
+
+// request is a single request, GET is ranged and we only look at the first bytes
+func (p *Prober) request(ctx context.Context, c *resty.Client, method, str, auth string) (answer, error) {
+	r := c.R().
+		SetContext(ctx).
+		SetHeader("User-Agent", fmt.Sprintf("%s/%s", MyName, MyVersion))
+
+	if auth != "" {
+		r = r.SetHeader("Proxy-Authorization", auth)
+	}
+
+	if method == http.MethodGet {
+		r = r.SetHeader("Range", fmt.Sprintf("bytes=0-%d", p.Limit-1)).
+			SetDoNotParseResponse(true)
+	}
+
+	resp, err := r.Execute(method, str)
+	if err != nil {
+		return answer{Method: method}, err
+	}
+
+	a := answer{
+		Method: method,
+		Code:   resp.StatusCode(),
+		Header: resp.Header(),
+	}
+
+	if method == http.MethodGet {
+		body := resp.RawBody()
+		defer body.Close()
+
+		a.Body, _ = ioutil.ReadAll(io.LimitReader(body, p.Limit))
+		// The connection is kept for the next leg only if the body was read
+		if a.Code == http.StatusProxyAuthRequired {
+			io.Copy(ioutil.Discard, body)
+		}
+	} else {
+		a.Body = resp.Body()
+	}
+	return a, nil
+}
@@ -0,0 +1,154 @@
+package cimbl
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProber(t *testing.T) {
+	p, err := NewProber(nil, 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMethods, p.Methods)
+	assert.EqualValues(t, DefaultLimit, p.Limit)
+	assert.True(t, p.Fallback[http.StatusMethodNotAllowed])
+}
+
+func TestNewProberGet(t *testing.T) {
+	p, err := NewProber([]string{"get"}, 16, []int{404})
+	require.NoError(t, err)
+	assert.Equal(t, []string{http.MethodGet}, p.Methods)
+	assert.EqualValues(t, 16, p.Limit)
+	assert.True(t, p.Fallback[http.StatusNotFound])
+	assert.False(t, p.Fallback[http.StatusMethodNotAllowed])
+}
+
+func TestNewProberBad(t *testing.T) {
+	p, err := NewProber([]string{"POST"}, 0, nil)
+	assert.Error(t, err)
+	assert.Nil(t, p)
+}
+
+func TestCheckURLHead(t *testing.T) {
+	defer gock.Off()
+
+	c := resty.New()
+
+	testSite, err := url.Parse(TestSite)
+	require.NoError(t, err)
+
+	gock.New(testSite.Host).
+		Head(testSite.Path).
+		Reply(200)
+
+	gock.InterceptClient(c.GetClient())
+	defer gock.RestoreClient(c.GetClient())
+
+	v, err := checkURL(&Context{}, c, TestSite)
+	assert.NoError(t, err)
+	assert.Equal(t, Verdict{Action: TestSite, Method: http.MethodHead, Code: 200}, v)
+}
+
+func TestCheckURLFallback(t *testing.T) {
+	defer gock.Off()
+
+	c := resty.New()
+
+	testSite, err := url.Parse(TestSite)
+	require.NoError(t, err)
+
+	gock.New(testSite.Host).
+		Head(testSite.Path).
+		Reply(405)
+	gock.New(testSite.Host).
+		Get(testSite.Path).
+		MatchHeader("Range", "bytes=0-511").
+		Reply(403)
+
+	gock.InterceptClient(c.GetClient())
+	defer gock.RestoreClient(c.GetClient())
+
+	v, err := checkURL(&Context{}, c, TestSite)
+	assert.NoError(t, err)
+	assert.Equal(t, Verdict{Action: ActionBlocked, Method: http.MethodGet, Code: 403}, v)
+	assert.True(t, gock.IsDone())
+}
+
+func TestCheckURLFallbackBody(t *testing.T) {
+	defer gock.Off()
+	ctx := rulesContext(t, "config-rules.toml")
+
+	c := resty.New()
+
+	testSite, err := url.Parse(TestSite)
+	require.NoError(t, err)
+
+	gock.New(testSite.Host).
+		Head(testSite.Path).
+		Reply(501)
+	gock.New(testSite.Host).
+		Get(testSite.Path).
+		Reply(200).
+		BodyString("<html><h1>Access Denied</h1></html>")
+
+	gock.InterceptClient(c.GetClient())
+	defer gock.RestoreClient(c.GetClient())
+
+	v, err := checkURL(ctx, c, TestSite)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionBlocked, v.Action)
+	assert.Equal(t, http.MethodGet, v.Method)
+}
+
+func TestProber_ProbeLimit(t *testing.T) {
+	defer gock.Off()
+
+	p, err := NewProber([]string{"GET"}, 4, nil)
+	require.NoError(t, err)
+
+	c := resty.New()
+
+	testSite, err := url.Parse(TestSite)
+	require.NoError(t, err)
+
+	gock.New(testSite.Host).
+		Get(testSite.Path).
+		MatchHeader("Range", "bytes=0-3").
+		Reply(200).
+		BodyString("0123456789")
+
+	gock.InterceptClient(c.GetClient())
+	defer gock.RestoreClient(c.GetClient())
+
+	a, err := p.Probe(&Context{}, c, TestSite)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123"), a.Body)
+}
+
+func TestURL_CheckVerdict(t *testing.T) {
+	defer gock.Off()
+
+	c := resty.New()
+
+	testSite, err := url.Parse(TestSite)
+	require.NoError(t, err)
+
+	gock.New(testSite.Host).
+		Head(testSite.Path).
+		Reply(405)
+	gock.New(testSite.Host).
+		Get(testSite.Path).
+		Reply(200)
+
+	gock.InterceptClient(c.GetClient())
+	defer gock.RestoreClient(c.GetClient())
+
+	u := NewURL(TestSite)
+	assert.True(t, u.Check(&Context{}, NewProxy(DefaultProxy, c)))
+	assert.Equal(t, http.MethodGet, u.V[DefaultProxy].Method)
+}
@@ -1,24 +1,135 @@
-package main
+package cimbl
+
+import (
+	"sort"
+	"time"
+)
+
+// Metadata describes the run the results come from
+type Metadata struct {
+	// Program is our name and version
+	Program  string
+	Started  time.Time
+	Duration time.Duration
+	// Inputs are the files or lists given
+	Inputs  []string
+	Proxies []string
+}
 
 type Results struct {
 	files []string
-	Paths map[string]bool
-	URLs  map[string]bool
+	// failed are the inputs we could not read
+	failed error
+
+	Paths   map[string]bool
+	URLs    map[string]bool
+	Domains map[string]bool
+
+	// Hashes are the file hashes to block
+	Hashes map[string]bool
+
+	// Networks are the ranges from IP lists
+	Networks map[string]bool
+
+	// Unchecked are the URLs we did not have time to check or can not check (ftp…)
+	Unchecked map[string]bool
+
+	// Verdicts are per URL then per proxy
+	Verdicts map[string]map[string]Verdict
+
+	// Blocklisted are the blocklist verdicts of the domains and networks, per entry then
+	// blocklist, those of the URLs being in Verdicts
+	Blocklisted map[string]map[string]Verdict `json:",omitempty"`
+
+	// DNS are the DNS firewall verdicts per host
+	DNS map[string]string
+
+	// Aggregated are the domain-level blocks replacing many URLs
+	Aggregated map[string][]string
+
+	// Allowed are the entries protected by the allowlist, with the reason
+	Allowed map[string]string
+
+	// Origins are the CIMBL files and indicators each entry comes from
+	Origins map[string][]Origin `json:",omitempty"`
+
+	// Threats are the CIMBL threat levels of the entries, the highest one if several
+	Threats map[string]string `json:",omitempty"`
+
+	Meta Metadata
 }
 
 func NewResults() *Results {
 	return &Results{
-		Paths: map[string]bool{},
-		URLs:  map[string]bool{},
+		Paths:     map[string]bool{},
+		URLs:      map[string]bool{},
+		Domains:   map[string]bool{},
+		Networks:  map[string]bool{},
+		Hashes:    map[string]bool{},
+		Unchecked: map[string]bool{},
+		Verdicts:  map[string]map[string]Verdict{},
+		DNS:       map[string]string{},
 	}
 }
 
+// fill creates the maps missing from a saved file
+func (r *Results) fill() {
+	if r.Paths == nil {
+		r.Paths = map[string]bool{}
+	}
+	if r.URLs == nil {
+		r.URLs = map[string]bool{}
+	}
+	if r.Domains == nil {
+		r.Domains = map[string]bool{}
+	}
+	if r.Networks == nil {
+		r.Networks = map[string]bool{}
+	}
+	if r.Hashes == nil {
+		r.Hashes = map[string]bool{}
+	}
+	if r.Unchecked == nil {
+		r.Unchecked = map[string]bool{}
+	}
+	if r.Verdicts == nil {
+		r.Verdicts = map[string]map[string]Verdict{}
+	}
+	if r.DNS == nil {
+		r.DNS = map[string]string{}
+	}
+}
+
+// Files are the CIMBL files the results come from
+func (r *Results) Files() []string {
+	return r.files
+}
+
+// Failed returns the inputs we could not read, nil if none
+func (r *Results) Failed() error {
+	return r.failed
+}
+
 func (r *Results) Add(t string, e string) *Results {
 	switch t {
 	case "filename":
 		r.Paths[e] = true
 	case "url":
 		r.URLs[e] = true
+	case "domain":
+		r.Domains[e] = true
+	case "hash":
+		if r.Hashes == nil {
+			r.Hashes = map[string]bool{}
+		}
+		r.Hashes[e] = true
+	case "network":
+		if r.Networks == nil {
+			r.Networks = map[string]bool{}
+		}
+		r.Networks[e] = true
+	case "unchecked":
+		r.Unchecked[e] = true
 	}
 	return r
 }
@@ -30,5 +141,176 @@ func (r *Results) Merge(s *Results) *Results {
 	for u, _ := range s.URLs {
 		r.URLs[u] = true
 	}
+	for d, _ := range s.Domains {
+		if r.Domains == nil {
+			r.Domains = map[string]bool{}
+		}
+		r.Domains[d] = true
+	}
+	for h := range s.Hashes {
+		r.Add("hash", h)
+	}
+	for n := range s.Networks {
+		r.Add("network", n)
+	}
+	for u, all := range s.Verdicts {
+		for p, v := range all {
+			r.AddVerdict(u, p, v)
+		}
+	}
+	for e, all := range s.Blocklisted {
+		for b, v := range all {
+			r.AddBlocklisted(e, b, v)
+		}
+	}
+	for h, v := range s.DNS {
+		r.AddDNS(h, v)
+	}
+	for key, all := range s.Aggregated {
+		r.AddAggregated(key, all...)
+	}
+	for e, why := range s.Allowed {
+		r.AddAllowed(e, why)
+	}
+	for e, all := range s.Origins {
+		r.AddOrigin(e, all...)
+	}
+	for e, level := range s.Threats {
+		r.AddThreat(e, level)
+	}
+	for u, _ := range s.Unchecked {
+		if r.Unchecked == nil {
+			r.Unchecked = map[string]bool{}
+		}
+		r.Unchecked[u] = true
+	}
+	return r
+}
+
+// AddVerdict records what proxy said about u
+func (r *Results) AddVerdict(u, proxy string, v Verdict) *Results {
+	if r.Verdicts == nil {
+		r.Verdicts = map[string]map[string]Verdict{}
+	}
+	if r.Verdicts[u] == nil {
+		r.Verdicts[u] = map[string]Verdict{}
+	}
+	r.Verdicts[u][proxy] = v
+	return r
+}
+
+// AddBlocklisted records what the blocklist name said about the domain or network e
+func (r *Results) AddBlocklisted(e, name string, v Verdict) *Results {
+	if r.Blocklisted == nil {
+		r.Blocklisted = map[string]map[string]Verdict{}
+	}
+	if r.Blocklisted[e] == nil {
+		r.Blocklisted[e] = map[string]Verdict{}
+	}
+	r.Blocklisted[e][name] = v
+	return r
+}
+
+// Origin is the CIMBL file and indicator an entry comes from
+type Origin struct {
+	File string
+	UUID string
+}
+
+// AddOrigin records where e comes from, once
+func (r *Results) AddOrigin(e string, all ...Origin) *Results {
+	if r.Origins == nil {
+		r.Origins = map[string][]Origin{}
+	}
+	for _, o := range all {
+		found := false
+		for _, old := range r.Origins[e] {
+			if old == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.Origins[e] = append(r.Origins[e], o)
+		}
+	}
 	return r
 }
+
+// AddThreat records the threat level of e, keeping the highest one
+func (r *Results) AddThreat(e, level string) *Results {
+	if r.Threats == nil {
+		r.Threats = map[string]string{}
+	}
+	if old, ok := r.Threats[e]; !ok || threatRank(level) > threatRank(old) {
+		r.Threats[e] = level
+	}
+	return r
+}
+
+// AddDNS records the DNS firewall verdict for host
+func (r *Results) AddDNS(host, v string) *Results {
+	if r.DNS == nil {
+		r.DNS = map[string]string{}
+	}
+	r.DNS[host] = v
+	return r
+}
+
+// Interrupted returns the number of unchecked URLs the run had no time for, the others are
+// those we can not check at all
+func (r *Results) Interrupted() int {
+	var n int
+	for u := range r.Unchecked {
+		for _, v := range r.Verdicts[u] {
+			if v.Action == ActionUnchecked {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// Proxies returns the names of the proxies we have verdicts from
+func (r *Results) Proxies() []string {
+	seen := map[string]bool{}
+	for _, all := range r.Verdicts {
+		for p := range all {
+			seen[p] = true
+		}
+	}
+
+	names := []string{}
+	for p := range seen {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PassedBy returns the URLs and https or aggregated domains proxy still lets through
+func (r *Results) PassedBy(proxy string) (map[string]bool, map[string]bool) {
+	urls := map[string]bool{}
+	domains := map[string]bool{}
+
+	aggregated := r.aggregatedBy()
+	for u, all := range r.Verdicts {
+		if v, ok := all[proxy]; !ok || v.Action != u {
+			continue
+		}
+		if _, ok := r.Allowed[u]; ok {
+			continue
+		}
+		if key, ok := aggregated[u]; ok {
+			domains[key] = true
+			continue
+		}
+		if host, ok := httpsHost(u); ok {
+			domains[host] = true
+		} else {
+			urls[u] = true
+		}
+	}
+	return urls, domains
+}
@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"testing"
@@ -71,3 +71,38 @@ func TestResults_Merge2(t *testing.T) {
 	assert.EqualValues(t, r1, mm)
 	assert.EqualValues(t, r1, tt)
 }
+
+func TestResults_AddVerdict(t *testing.T) {
+	r := NewResults()
+
+	r.AddVerdict("http://example.com/", "a", Verdict{Action: ActionBlocked})
+	r.AddVerdict("http://example.com/", "b", Verdict{Action: "http://example.com/"})
+	r.AddVerdict("https://example.net/foo", "b", Verdict{Action: "https://example.net/foo"})
+
+	assert.Equal(t, []string{"a", "b"}, r.Proxies())
+
+	urls, domains := r.PassedBy("a")
+	assert.Empty(t, urls)
+	assert.Empty(t, domains)
+
+	urls, domains = r.PassedBy("b")
+	assert.Equal(t, map[string]bool{"http://example.com/": true}, urls)
+	assert.Equal(t, map[string]bool{"example.net": true}, domains)
+}
+
+func TestResults_MergeVerdicts(t *testing.T) {
+	r1 := NewResults().AddVerdict("http://example.com/", "a", Verdict{Action: ActionBlocked})
+	r2 := NewResults().AddVerdict("http://example.com/", "b", Verdict{Action: ActionAuth})
+
+	r1.Merge(r2)
+	assert.Len(t, r1.Verdicts["http://example.com/"], 2)
+}
+
+func TestResults_Interrupted(t *testing.T) {
+	r := NewResults()
+	r.Add("unchecked", "ftp://ftp.example.com/kit.zip")
+	r.Add("unchecked", "http://example.com/slow")
+	r.AddVerdict("http://example.com/slow", DefaultProxy, Verdict{Action: ActionUnchecked})
+
+	assert.Equal(t, 1, r.Interrupted())
+}
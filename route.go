@@ -0,0 +1,172 @@
+package cimbl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RouteConfig sends the indicators of some types to their own team, with its own templates.
+// Types nobody gets go to the default recipients.
+//
+// [[route]]
+// name = "proxy"
+// types = ["url", "domain", "aggregated", "dns"]
+// to = "proxy-team@example.com"
+// template = { text = "/etc/erc-cimbl/proxy.tmpl" }
+type RouteConfig struct {
+	Name string
+	// Types are from IndicatorTypes, ip is the same as network
+	Types   []string
+	To      string
+	Cc      string
+	Subject string
+	// Template replaces the global one for this team
+	Template *TemplateConfig
+}
+
+// route is the checked version of RouteConfig
+type route struct {
+	name    string
+	types   map[string]bool
+	to      string
+	cc      string
+	subject string
+	tmpl    *reportTemplates
+}
+
+// compileRoutes checks the routes and adds the default one for the types nobody gets
+func compileRoutes(config *Config, tmpl *reportTemplates) ([]*route, error) {
+	if len(config.Routes) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]bool{}
+	for _, t := range IndicatorTypes {
+		known[t] = true
+	}
+
+	routes := []*route{}
+	routed := map[string]bool{}
+	for _, rc := range config.Routes {
+		if rc.Name == "" || rc.To == "" || len(rc.Types) == 0 {
+			return nil, fmt.Errorf("route needs name, to and types")
+		}
+
+		rt := &route{name: rc.Name, types: map[string]bool{}, to: rc.To, cc: rc.Cc, subject: rc.Subject, tmpl: tmpl}
+		for _, t := range rc.Types {
+			t = strings.ToLower(t)
+			if t == "ip" {
+				t = "network"
+			}
+			if !known[t] {
+				return nil, fmt.Errorf("route %s: unknown type %s", rc.Name, t)
+			}
+			rt.types[t] = true
+			routed[t] = true
+		}
+
+		if rc.Template != nil {
+			var err error
+			if rt.tmpl, err = loadTemplates(rc.Template); err != nil {
+				return nil, fmt.Errorf("route %s: %v", rc.Name, err)
+			}
+		}
+		routes = append(routes, rt)
+	}
+
+	// Unchecked URLs go with the URLs
+	if routed["url"] {
+		routed["unchecked"] = true
+	}
+
+	rest := &route{name: "default", types: map[string]bool{}, to: config.To, cc: config.Cc, tmpl: tmpl}
+	for _, t := range IndicatorTypes {
+		if !routed[t] {
+			rest.types[t] = true
+		}
+	}
+	if len(rest.types) != 0 {
+		routes = append(routes, rest)
+	}
+	return routes, nil
+}
+
+// only returns the part of r with these types, unchecked URLs going with the URLs and
+// verdicts with the type their URL is reported as
+func (r *Results) only(types map[string]bool) *Results {
+	s := NewResults()
+	s.files = r.files
+	s.failed = r.failed
+	s.Meta = r.Meta
+	s.Origins = r.Origins
+	s.Threats = r.Threats
+
+	copyKeys := func(t string, from, to map[string]bool) {
+		if types[t] {
+			for k, v := range from {
+				to[k] = v
+			}
+		}
+	}
+	copyKeys("url", r.URLs, s.URLs)
+	copyKeys("domain", r.Domains, s.Domains)
+	copyKeys("network", r.Networks, s.Networks)
+	copyKeys("filename", r.Paths, s.Paths)
+	copyKeys("hash", r.Hashes, s.Hashes)
+	copyKeys("unchecked", r.Unchecked, s.Unchecked)
+	copyKeys("url", r.Unchecked, s.Unchecked)
+
+	// Verdicts go with what the URL is blocked as
+	aggregated := r.aggregatedBy()
+	for u, all := range r.Verdicts {
+		if types[verdictType(u, aggregated)] {
+			s.Verdicts[u] = all
+		}
+	}
+	for e, all := range r.Blocklisted {
+		t := "domain"
+		if parseNet(e) != nil {
+			t = "network"
+		}
+		if types[t] {
+			for b, v := range all {
+				s.AddBlocklisted(e, b, v)
+			}
+		}
+	}
+	if types["aggregated"] {
+		for k, v := range r.Aggregated {
+			s.AddAggregated(k, v...)
+		}
+	}
+	if types["dns"] {
+		for k, v := range r.DNS {
+			s.DNS[k] = v
+		}
+	}
+	if types["allowed"] {
+		for k, v := range r.Allowed {
+			s.AddAllowed(k, v)
+		}
+	}
+	return s
+}
+
+// verdictType is the type an URL with verdicts is reported as: https ones are blocked at the
+// domain level and those of an aggregated domain with it
+func verdictType(u string, aggregated map[string]string) string {
+	if _, ok := aggregated[u]; ok {
+		return "aggregated"
+	}
+	if _, ok := httpsHost(u); ok {
+		return "domain"
+	}
+	return "url"
+}
+
+// Empty is true when there is nothing to ask for nor to review
+func (r *Results) Empty() bool {
+	return len(r.Paths) == 0 && len(r.Hashes) == 0 && len(r.URLs) == 0 && len(r.Domains) == 0 &&
+		len(r.Networks) == 0 && len(r.Aggregated) == 0 && len(r.Unchecked) == 0 && len(resolving(r)) == 0 &&
+		len(r.Allowed) == 0
+}
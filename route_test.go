@@ -0,0 +1,201 @@
+package cimbl
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordMailer keeps the mails instead of sending them
+type recordMailer struct {
+	to   [][]string
+	text []string
+}
+
+func (m *recordMailer) SendMail(server, from string, to []string, text []byte) error {
+	m.to = append(m.to, to)
+	m.text = append(m.text, string(text))
+	return nil
+}
+
+func TestCompileRoutes(t *testing.T) {
+	config, err := LoadConfig("testdata/config-routes.toml")
+	require.NoError(t, err)
+
+	routes, err := compileRoutes(config, builtinTemplates)
+	require.NoError(t, err)
+	require.Len(t, routes, 3)
+
+	assert.Equal(t, "proxy", routes[0].name)
+	assert.NotEqual(t, builtinTemplates, routes[0].tmpl)
+	assert.Equal(t, builtinTemplates, routes[1].tmpl)
+
+	// What nobody gets, unchecked going with the URLs
+	rest := routes[2]
+	assert.Equal(t, "default", rest.name)
+	assert.Equal(t, config.To, rest.to)
+	assert.Equal(t, map[string]bool{"network": true, "allowed": true}, rest.types)
+}
+
+func TestCompileRoutesNone(t *testing.T) {
+	routes, err := compileRoutes(&Config{}, builtinTemplates)
+	assert.NoError(t, err)
+	assert.Nil(t, routes)
+}
+
+func TestCompileRoutesAll(t *testing.T) {
+	config := &Config{Routes: []RouteConfig{{Name: "all", To: "all@example.com", Types: IndicatorTypes}}}
+	routes, err := compileRoutes(config, builtinTemplates)
+	require.NoError(t, err)
+	assert.Len(t, routes, 1)
+}
+
+func TestCompileRoutesBad(t *testing.T) {
+	tests := []RouteConfig{
+		{Name: "", To: "a@example.com", Types: []string{"url"}},
+		{Name: "a", Types: []string{"url"}},
+		{Name: "a", To: "a@example.com"},
+		{Name: "a", To: "a@example.com", Types: []string{"smtp"}},
+		{Name: "a", To: "a@example.com", Types: []string{"url"}, Template: &TemplateConfig{Text: "testdata/broken.tmpl"}},
+	}
+	for _, rc := range tests {
+		_, err := compileRoutes(&Config{Routes: []RouteConfig{rc}}, builtinTemplates)
+		assert.Error(t, err, rc.Name)
+	}
+
+	_, err := NewContext(&Config{Routes: tests[:1]}, Options{})
+	assert.Error(t, err)
+}
+
+func TestCompileRoutesIP(t *testing.T) {
+	config := &Config{Routes: []RouteConfig{{Name: "fw", To: "fw@example.com", Types: []string{"IP"}}}}
+	routes, err := compileRoutes(config, builtinTemplates)
+	require.NoError(t, err)
+	assert.True(t, routes[0].types["network"])
+}
+
+func TestResults_Only(t *testing.T) {
+	res := SampleResults()
+
+	res.AddVerdict("http://www.example.org/a", DefaultProxy, Verdict{Action: "http://www.example.org/a"})
+
+	part := res.only(map[string]bool{"url": true})
+	assert.Equal(t, res.URLs, part.URLs)
+	assert.Equal(t, []string{"http://example.com/malware"}, keysOfVerdicts(part))
+	assert.Equal(t, res.Unchecked, part.Unchecked)
+	assert.Equal(t, res.Files(), part.Files())
+	assert.Empty(t, part.Paths)
+	assert.Empty(t, part.Aggregated)
+
+	part = res.only(map[string]bool{"hash": true, "dns": true, "allowed": true, "aggregated": true})
+	assert.Equal(t, res.Hashes, part.Hashes)
+	assert.Equal(t, res.DNS, part.DNS)
+	assert.Equal(t, res.Allowed, part.Allowed)
+	assert.Equal(t, res.Aggregated, part.Aggregated)
+	assert.Empty(t, part.URLs)
+	assert.Empty(t, part.Unchecked)
+	assert.Equal(t, []string{"http://www.example.org/a"}, keysOfVerdicts(part))
+
+	// https URLs are blocked as domains
+	part = res.only(map[string]bool{"domain": true})
+	assert.Equal(t, []string{"https://secure.example.net/login"}, keysOfVerdicts(part))
+	urls, domains := part.PassedBy(DefaultProxy)
+	assert.Empty(t, urls)
+	assert.Equal(t, map[string]bool{"secure.example.net": true}, domains)
+
+	assert.True(t, res.only(map[string]bool{}).Empty())
+}
+
+// keysOfVerdicts are the URLs with verdicts
+func keysOfVerdicts(r *Results) []string {
+	keys := []string{}
+	for u := range r.Verdicts {
+		keys = append(keys, u)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSendReportRoutes(t *testing.T) {
+	config, err := LoadConfig("testdata/config-routes.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{Mail: true})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	m := &recordMailer{}
+	ctx.SetMailer(m)
+
+	require.NoError(t, SendReport(ctx, SampleResults()))
+	require.Len(t, m.text, 3)
+
+	assert.Equal(t, [][]string{
+		{"proxy@example.com"},
+		{"mailgw@example.com", "soc@example.com"},
+		{"security@example.com", "root@example.com"},
+	}, m.to)
+
+	// Their own template and subject
+	assert.Contains(t, m.text[0], "Subject: CRQ: New URLs to be BLOCKED\nTo: proxy@example.com\n")
+	assert.Contains(t, m.text[0], "1 URLs from")
+	assert.NotContains(t, m.text[0], "invoice.docm")
+
+	assert.Contains(t, m.text[1], "Subject: "+config.Subject+"\nTo: mailgw@example.com\nCc: soc@example.com\n")
+	assert.Contains(t, m.text[1], "invoice.docm")
+	assert.NotContains(t, m.text[1], "http://example.com/malware")
+
+	assert.Contains(t, m.text[2], "192.0.2.0/24")
+	assert.Contains(t, m.text[2], allowedTmpl)
+	assert.NotContains(t, m.text[2], "invoice.docm")
+
+	all, err := ctx.Reports(SampleResults())
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func TestSendReportRoutesPartial(t *testing.T) {
+	config, err := LoadConfig("testdata/config-routes.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{Mail: true})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	m := &recordMailer{}
+	ctx.SetMailer(m)
+
+	// Only for the mail gateway team
+	res := NewResults()
+	res.Add("filename", "invoice.docm")
+
+	require.NoError(t, SendReport(ctx, res))
+	require.Len(t, m.text, 1)
+	assert.Contains(t, m.text[0], "To: mailgw@example.com\n")
+
+	all, err := ctx.Reports(res)
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestSendReportRoutesAllowed(t *testing.T) {
+	config, err := LoadConfig("testdata/config-routes.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{Mail: true})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	m := &recordMailer{}
+	ctx.SetMailer(m)
+
+	// Only for the default route, to be reviewed
+	res := NewResults().AddAllowed("www.microsoft.com", "domain microsoft.com")
+
+	require.NoError(t, SendReport(ctx, res))
+	require.Len(t, m.text, 1)
+	assert.Contains(t, m.text[0], "To: security@example.com\n")
+	assert.Contains(t, m.text[0], "www.microsoft.com (domain microsoft.com)")
+}
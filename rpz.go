@@ -0,0 +1,197 @@
+package cimbl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RPZ defaults, the zone is for a local resolver
+const (
+	DefaultRPZZone    = "rpz.local"
+	DefaultRPZNS      = "localhost."
+	DefaultRPZContact = "hostmaster.localhost."
+	DefaultRPZTTL     = 300
+)
+
+// RPZConfig describes the Response Policy Zone we export
+type RPZConfig struct {
+	// Zone is the origin of the zone
+	Zone string
+	// File is where the zone is written, also read back for the serial
+	File    string
+	NS      string
+	Contact string
+	TTL     int
+	// Sinkhole is an address or a name, empty means NXDOMAIN (CNAME .)
+	Sinkhole string
+	// Wildcard also blocks all the subdomains
+	Wildcard bool
+}
+
+// withDefaults fills the empty fields
+func (c RPZConfig) withDefaults() RPZConfig {
+	if c.Zone == "" {
+		c.Zone = DefaultRPZZone
+	}
+	if c.NS == "" {
+		c.NS = DefaultRPZNS
+	}
+	if c.Contact == "" {
+		c.Contact = DefaultRPZContact
+	}
+	if c.TTL == 0 {
+		c.TTL = DefaultRPZTTL
+	}
+	return c
+}
+
+// rpzHosts returns the sorted list of domains, aggregated ones and URL hosts from r. IP addresses are
+// left out as they need rpz-ip triggers.
+func rpzHosts(r *Results) []string {
+	seen := map[string]bool{}
+
+	for d := range r.Domains {
+		seen[strings.ToLower(strings.TrimSuffix(d, "."))] = true
+	}
+	for key := range r.Aggregated {
+		seen[key] = true
+	}
+	for u := range r.URLs {
+		myurl, err := sanitize(u)
+		if err != nil && err != ErrHttps {
+			continue
+		}
+		seen[strings.ToLower(hostOf(myurl))] = true
+	}
+
+	hosts := []string{}
+	for h := range seen {
+		if h == "" || net.ParseIP(h) != nil {
+			continue
+		}
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// rpzAction is the RDATA of every entry
+func rpzAction(sinkhole string) string {
+	if sinkhole == "" {
+		return "CNAME\t."
+	}
+	if ip := net.ParseIP(sinkhole); ip != nil {
+		if ip.To4() != nil {
+			return "A\t" + ip.String()
+		}
+		return "AAAA\t" + ip.String()
+	}
+	return "CNAME\t" + strings.TrimSuffix(sinkhole, ".") + "."
+}
+
+// nextSerial is YYYYMMDDnn, always above the previous one
+func nextSerial(prev uint32, now time.Time) uint32 {
+	serial, _ := strconv.ParseUint(now.Format("20060102")+"00", 10, 32)
+	if uint32(serial) <= prev {
+		return prev + 1
+	}
+	return uint32(serial)
+}
+
+// readSerial finds the SOA serial of an existing zone, 0 if there is none
+func readSerial(file string) uint32 {
+	fh, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer fh.Close()
+
+	s := bufio.NewScanner(fh)
+	for s.Scan() {
+		all := strings.Fields(s.Text())
+		for i, f := range all {
+			// SOA mname rname serial …
+			if f == "SOA" && i+3 < len(all) {
+				serial, err := strconv.ParseUint(all[i+3], 10, 32)
+				if err != nil {
+					return 0
+				}
+				return uint32(serial)
+			}
+		}
+	}
+	return 0
+}
+
+// WriteRPZ writes the zone for hosts with the given serial
+func WriteRPZ(w io.Writer, cnf RPZConfig, serial uint32, hosts []string) error {
+	cnf = cnf.withDefaults()
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "; Generated by %s/%s on %s\n", MyName, MyVersion, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&buf, "$ORIGIN %s.\n", strings.TrimSuffix(cnf.Zone, "."))
+	fmt.Fprintf(&buf, "$TTL %d\n", cnf.TTL)
+	fmt.Fprintf(&buf, "@\tIN\tSOA\t%s %s %d 3600 600 86400 %d\n", cnf.NS, cnf.Contact, serial, cnf.TTL)
+	fmt.Fprintf(&buf, "@\tIN\tNS\t%s\n", cnf.NS)
+	fmt.Fprintf(&buf, "\n")
+
+	action := rpzAction(cnf.Sinkhole)
+	for _, h := range hosts {
+		fmt.Fprintf(&buf, "%s\t%s\n", h, action)
+		if cnf.Wildcard {
+			fmt.Fprintf(&buf, "*.%s\t%s\n", h, action)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ExportRPZ writes the zone file from the results, bumping the serial of the previous one
+func ExportRPZ(cnf RPZConfig, r *Results) error {
+	if cnf.File == "" {
+		return nil
+	}
+
+	serial := nextSerial(readSerial(cnf.File), time.Now())
+
+	// Write to a temporary file then rename so the resolver never sees a partial zone
+	fh, err := ioutil.TempFile(filepath.Dir(cnf.File), ".rpz")
+	if err != nil {
+		return errors.Wrap(err, "rpz/create")
+	}
+
+	hosts := rpzHosts(r)
+	if err := WriteRPZ(fh, cnf, serial, hosts); err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return errors.Wrap(err, "rpz/write")
+	}
+	if err := fh.Close(); err != nil {
+		os.Remove(fh.Name())
+		return errors.Wrap(err, "rpz/close")
+	}
+	if err := os.Chmod(fh.Name(), 0644); err != nil {
+		os.Remove(fh.Name())
+		return errors.Wrap(err, "rpz/chmod")
+	}
+	if err := os.Rename(fh.Name(), cnf.File); err != nil {
+		os.Remove(fh.Name())
+		return errors.Wrap(err, "rpz/rename")
+	}
+	verbose("rpz: %d entries in %s, serial %d", len(hosts), cnf.File, serial)
+	return nil
+}
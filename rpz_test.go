@@ -0,0 +1,101 @@
+package cimbl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRpzHosts(t *testing.T) {
+	r := NewResults()
+	r.Add("domain", "Evil.Example.com.")
+	r.Add("url", "http://www.example.net/search.php")
+	r.Add("url", "http://evil.example.com/foo")
+	r.Add("url", "http://10.1.2.3/foo")
+	r.Add("filename", "foo.exe")
+	r.AddAggregated("example.org", "http://www.example.org/a")
+
+	assert.Equal(t, []string{"evil.example.com", "example.org", "www.example.net"}, rpzHosts(r))
+}
+
+func TestRpzHosts_Empty(t *testing.T) {
+	assert.Empty(t, rpzHosts(NewResults()))
+}
+
+func TestRpzAction(t *testing.T) {
+	td := []struct{ in, out string }{
+		{"", "CNAME\t."},
+		{"10.0.0.1", "A\t10.0.0.1"},
+		{"::1", "AAAA\t::1"},
+		{"walled.example.com", "CNAME\twalled.example.com."},
+		{"walled.example.com.", "CNAME\twalled.example.com."},
+	}
+	for _, d := range td {
+		assert.Equal(t, d.out, rpzAction(d.in))
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, uint32(2019070100), nextSerial(0, now))
+	assert.Equal(t, uint32(2019070100), nextSerial(2019063005, now))
+	assert.Equal(t, uint32(2019070101), nextSerial(2019070100, now))
+	assert.Equal(t, uint32(2019070243), nextSerial(2019070242, now))
+}
+
+func TestWriteRPZ(t *testing.T) {
+	var buf bytes.Buffer
+
+	cnf := RPZConfig{Zone: "rpz.example.com", Wildcard: true}
+	require.NoError(t, WriteRPZ(&buf, cnf, 2019070100, []string{"evil.example.com"}))
+
+	out := buf.String()
+	assert.Contains(t, out, "$ORIGIN rpz.example.com.\n")
+	assert.Contains(t, out, "$TTL 300\n")
+	assert.Contains(t, out, "@\tIN\tSOA\tlocalhost. hostmaster.localhost. 2019070100 3600 600 86400 300\n")
+	assert.Contains(t, out, "evil.example.com\tCNAME\t.\n")
+	assert.Contains(t, out, "*.evil.example.com\tCNAME\t.\n")
+}
+
+func TestReadSerial_None(t *testing.T) {
+	assert.Equal(t, uint32(0), readSerial("/nonexistent"))
+}
+
+func TestExportRPZ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpz")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "db.rpz")
+	cnf := RPZConfig{File: file, Sinkhole: "10.0.0.1"}
+
+	r := NewResults()
+	r.Add("domain", "evil.example.com")
+
+	require.NoError(t, ExportRPZ(cnf, r))
+	first := readSerial(file)
+	assert.Equal(t, nextSerial(0, time.Now()), first)
+
+	buf, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), "evil.example.com\tA\t10.0.0.1\n")
+
+	// Next run gets a new serial
+	require.NoError(t, ExportRPZ(cnf, r))
+	assert.Equal(t, first+1, readSerial(file))
+}
+
+func TestExportRPZ_NoFile(t *testing.T) {
+	assert.NoError(t, ExportRPZ(RPZConfig{}, NewResults()))
+}
+
+func TestExportRPZ_BadDir(t *testing.T) {
+	assert.Error(t, ExportRPZ(RPZConfig{File: "/nonexistent/db.rpz"}, NewResults()))
+}
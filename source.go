@@ -1,9 +1,11 @@
-package main
+package cimbl
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/csv"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -18,32 +20,211 @@ import (
 // -----
 
 type Sourcer interface {
-	Check(req *resty.Client) bool
+	Check(ctx *Context, p *Proxy) bool
 	AddTo(r *Results)
 }
 
+// Proxy is one of the proxies we check against
+type Proxy struct {
+	Name   string
+	Client *resty.Client
+}
+
+// DefaultProxy is the name used when none is configured
+const DefaultProxy = "default"
+
+func NewProxy(name string, c *resty.Client) *Proxy {
+	return &Proxy{Name: name, Client: c}
+}
+
 type URL struct {
 	H string
+	// V are the verdicts per proxy
+	V map[string]Verdict
+	// DNS is the verdict of the DNS firewall, if any
+	DNS string
 }
 
 func NewURL(u string) *URL {
-	return &URL{H: u}
+	return &URL{H: u, V: map[string]Verdict{}}
 }
 
-// XXX
-func (u *URL) Check(c *resty.Client) bool {
-	r, _ := handleURL(c, u.H)
-	if r == u.H {
+// Check probes the URL through p and keeps the verdict, true means "block it" or that
+// we were interrupted and it must be reported as unchecked.
+func (u *URL) Check(ctx *Context, p *Proxy) bool {
+	if u.V == nil {
+		u.V = map[string]Verdict{}
+	}
+
+	// No traffic at all against a blocklist, we want all verdicts
+	if ctx.blocklist != nil {
+		v := ctx.blocklist.Verdict("url", u.H)
+		u.V[p.Name] = v
+		verbose("%s/%s: %s %s", p.Name, u.H, v.Action, v.Rule)
 		return true
 	}
+
+	// Only once for all proxies
+	if u.DNS == "" {
+		u.DNS = checkDNS(ctx, u.H)
+	}
+
+	v, err := checkURL(ctx, p.Client, u.H)
+	if err != nil {
+		debug("%s: %v", u.H, err)
+	}
+	if ctx.Run().Err() != nil {
+		v.Action = ActionUnchecked
+		u.V[p.Name] = v
+		return true
+	}
+	u.V[p.Name] = v
+	verbose("%s/%s: %s (%s %d)", p.Name, u.H, v.Action, v.Method, v.Code)
+	return v.Action == u.H || u.DNS == DNSResolves
+}
+
+// passes is true if any of the proxies lets it through or if it was not probed
+func (u *URL) passes() bool {
+	if len(u.V) == 0 {
+		return true
+	}
+	for _, v := range u.V {
+		if v.Action == u.H {
+			return true
+		}
+	}
 	return false
 }
 
+// unchecked is true if any of the proxies could not be checked
+func (u *URL) unchecked() bool {
+	for _, v := range u.V {
+		if v.Action == ActionUnchecked {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTo records the URL, https ones are blocked at the domain level (no MITM)
 func (u *URL) AddTo(r *Results) {
 	verbose("U")
+	for name, v := range u.V {
+		r.AddVerdict(u.H, name, v)
+	}
+	if u.unchecked() {
+		r.Add("unchecked", u.H)
+		return
+	}
+	if u.DNS != "" {
+		myurl, _ := sanitize(u.H)
+		r.AddDNS(hostOf(myurl), u.DNS)
+	}
+	if !u.passes() {
+		return
+	}
+	if host, ok := httpsHost(u.H); ok {
+		r.Add("domain", host)
+		return
+	}
 	r.Add("url", u.H)
 }
 
+// Domain is a hostname indicator, blocked at the domain level
+type Domain struct {
+	Name string
+	// DNS is the verdict of the DNS firewall, if any
+	DNS string
+	// V are the verdicts of the blocklist, if any
+	V map[string]Verdict
+}
+
+func NewDomain(s string) *Domain {
+	name := strings.ToLower(strings.TrimSuffix(Refang(s), "."))
+	if host, err := canonHost(name); err == nil {
+		name = host
+	}
+	return &Domain{Name: name}
+}
+
+// Check is only for the DNS firewall, true if it does not block it already
+func (d *Domain) Check(ctx *Context, p *Proxy) bool {
+	if ctx.blocklist != nil {
+		d.V = map[string]Verdict{p.Name: ctx.blocklist.Verdict("domain", d.Name)}
+		return true
+	}
+	if d.DNS == "" {
+		d.DNS = checkDNS(ctx, d.Name)
+	}
+	return d.DNS != DNSBlocked
+}
+
+func (d *Domain) AddTo(r *Results) {
+	verbose("D")
+	if d.DNS != "" {
+		r.AddDNS(d.Name, d.DNS)
+	}
+	if !listed(r, d.Name, d.V) {
+		r.Add("domain", d.Name)
+	}
+}
+
+// Network is a range from an IP list, blocked as a whole
+type Network struct {
+	CIDR string
+	// V are the verdicts of the blocklist, if any
+	V map[string]Verdict
+}
+
+func NewNetwork(s string) *Network {
+	return &Network{CIDR: s}
+}
+
+// Check is always true, ranges are not probed
+func (n *Network) Check(ctx *Context, p *Proxy) bool {
+	if ctx.blocklist != nil {
+		n.V = map[string]Verdict{p.Name: ctx.blocklist.Verdict("network", n.CIDR)}
+	}
+	return true
+}
+
+func (n *Network) AddTo(r *Results) {
+	verbose("N")
+	if !listed(r, n.CIDR, n.V) {
+		r.Add("network", n.CIDR)
+	}
+}
+
+// listed records the blocklist verdicts of e, true if the list has it already
+func listed(r *Results, e string, all map[string]Verdict) bool {
+	found := false
+	for name, v := range all {
+		r.AddBlocklisted(e, name, v)
+		if v.Action != e {
+			found = true
+		}
+	}
+	return found
+}
+
+// Hash is a file hash (MD5, SHA1 or SHA256)
+type Hash struct {
+	Sum string
+}
+
+func NewHash(s string) *Hash {
+	return &Hash{Sum: strings.ToLower(s)}
+}
+
+func (h *Hash) Check(ctx *Context, p *Proxy) bool {
+	return true
+}
+
+func (h *Hash) AddTo(r *Results) {
+	verbose("H")
+	r.Add("hash", h.Sum)
+}
+
 type Filename struct {
 	Name string
 }
@@ -52,7 +233,7 @@ func NewFilename(s string) *Filename {
 	return &Filename{Name: s}
 }
 
-func (f *Filename) Check(c *resty.Client) bool {
+func (f *Filename) Check(ctx *Context, p *Proxy) bool {
 	return true
 }
 
@@ -61,42 +242,80 @@ func (f *Filename) AddTo(r *Results) {
 	r.Add("filename", f.Name)
 }
 
+// Unsupported is an URL we can not check, like ftp ones, it is to be blocked by hand
+type Unsupported struct {
+	H string
+}
+
+func NewUnsupported(u string) *Unsupported {
+	return &Unsupported{H: Refang(u)}
+}
+
+func (u *Unsupported) Check(ctx *Context, p *Proxy) bool {
+	return true
+}
+
+func (u *Unsupported) AddTo(r *Results) {
+	verbose("N")
+	r.Add("unchecked", u.H)
+}
+
 // -----
 
 type List struct {
 	s     []Sourcer
 	files []string
+	opts  Options
+	// origins are the CIMBL indicators of the entries and their threat levels
+	origins *Results
 }
 
-// NewList create a new list from sources, either URL or a CIMBL filename
-// NewList() with another filename is not supported although an URL is.
+// NewList create a new list from sources, URLs, files or "-" for stdin, guessing their
+// type.  Bad inputs are logged, see LoadList.
 func NewList(files []string) *List {
-	if files == nil || len(files) == 0 {
-		return &List{}
+	l, err := LoadList(files, Options{})
+	if lerr, ok := err.(*ListError); ok {
+		for _, in := range lerr.Inputs {
+			log.Printf("%s: %v", in.Name, in.Err)
+		}
 	}
+	return l
+}
 
-	l := &List{}
+// LoadList is NewList returning every input it could not read, opts giving the input
+// type and how to read them
+func LoadList(files []string, opts Options) (*List, error) {
+	l := &List{opts: opts}
 
+	var lerr ListError
 	for _, e := range files {
-		if strings.HasPrefix(e, "http:") {
-			l.Add(NewURL(e))
-		} else if strings.HasSuffix(e, ".txt") {
-			if l, err := l.AddFromIP(e); err != nil {
-				log.Printf("Invalid IP list: %s", e)
-				return l
-			}
-		} else if REFile.MatchString(e) {
-			var err error
-
-			l, err = l.AddFromFile(e)
-			if err != nil {
-				log.Printf("%v: reading error: %v", e, errors.Wrap(err, "AddFromFile"))
-			}
-		} else {
-			log.Printf("invalid filename")
+		if _, err := l.AddInput(e, opts.Input); err != nil {
+			lerr.Inputs = append(lerr.Inputs, BadInput{Name: e, Err: err})
 		}
 	}
-	return l
+	if len(lerr.Inputs) != 0 {
+		return l, &lerr
+	}
+	return l, nil
+}
+
+// BadInput is an input we could not read, fully or partly
+type BadInput struct {
+	Name string
+	Err  error
+}
+
+// ListError is every bad input of a list
+type ListError struct {
+	Inputs []BadInput
+}
+
+func (e *ListError) Error() string {
+	all := []string{}
+	for _, in := range e.Inputs {
+		all = append(all, fmt.Sprintf("%s: %v", in.Name, in.Err))
+	}
+	return fmt.Sprintf("%d bad inputs: %s", len(e.Inputs), strings.Join(all, "; "))
 }
 
 func (l *List) Add(s Sourcer) *List {
@@ -109,6 +328,8 @@ func (l *List) Length() int {
 	return len(l.s)
 }
 
+// AddFromFile reads a CIMBL file, decrypting and unpacking it if needed.  An empty one is
+// not an error, there is just nothing to block in it.
 func (l *List) AddFromFile(fn string) (*List, error) {
 	var (
 		base string
@@ -141,9 +362,16 @@ func (l *List) AddFromFile(fn string) (*List, error) {
 	}
 
 	l.files = append(l.files, filepath.Base(base))
-	return l.ReadFromCSV(buf)
+
+	// An empty CIMBL is not an error, just nothing to do
+	if buf.Len() == 0 {
+		verbose("%s is empty", base)
+		return l, nil
+	}
+	return l.readCSV(buf, filepath.Base(base))
 }
 
+// AddFromIP reads an IP list, invalid lines are reported but the valid ones are still added
 func (l *List) AddFromIP(fn string) (*List, error) {
 	if _, err := os.Stat(fn); err != nil {
 		return l, errors.Wrapf(err, "unknown fn %s", fn)
@@ -154,18 +382,36 @@ func (l *List) AddFromIP(fn string) (*List, error) {
 		return nil, errors.Wrap(err, "addfromip")
 	}
 
-	s := bufio.NewScanner(buf)
-	for s.Scan() {
-		l.Add(NewURL(fmt.Sprintf("http://%s/", s.Text())))
+	entries, err := parseIPList(buf)
+	for _, e := range entries {
+		for _, s := range e.sources(l.opts.IPExpand) {
+			l.Add(s)
+		}
+	}
+	if err != nil {
+		return l, errors.Wrapf(err, "%s", fn)
 	}
 	return l, nil
 }
 
+// ReadFromCSV reads CIMBL data without file name, see readCSV
 func (l *List) ReadFromCSV(r io.Reader) (*List, error) {
-	allLines := csvplus.FromReader(r).SelectColumns("type", "value", "to_ids")
+	return l.readCSV(r, "-")
+}
+
+// readCSV adds the indicators we can block, keeping the file and the indicator UUID of each.
+// URLs we can not check, like ftp ones, are listed as unchecked to be blocked by hand.
+func (l *List) readCSV(r io.Reader, file string) (*List, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return l, errors.Wrap(err, "reading csv")
+	}
+	allLines := csvplus.FromReader(bytes.NewReader(buf)).SelectColumns(csvColumns(buf)...)
 	rows, err := csvplus.Take(allLines).
 		Filter(csvplus.Any(csvplus.Like(csvplus.Row{"type": "url"}),
 			csvplus.Like(csvplus.Row{"type": "filename"}),
+			csvplus.Like(csvplus.Row{"type": "domain"}),
+			csvplus.Like(csvplus.Row{"type": "hostname"}),
 			csvplus.Like(csvplus.Row{"type": "filename|sha1"}))).
 		ToRows()
 	if err != nil {
@@ -181,29 +427,129 @@ func (l *List) ReadFromCSV(r io.Reader) (*List, error) {
 		switch rt {
 		case "filename":
 			fn := strings.Split(row["value"], "|")[0]
-			l.Add(NewFilename(fn))
+			l.Add(NewFilename(fn)).addThreat(fn, row["indicator_threat_level"])
+		case "domain", "hostname":
+			if row["to_ids"] == "1" {
+				d := NewDomain(row["value"])
+				l.Add(d).addOrigin(d.Name, rowOrigin(file, row))
+				l.addThreat(d.Name, row["indicator_threat_level"])
+			}
 		case "url":
 			// if to_ids is set to 0, do not auto block.
 			if row["to_ids"] == "1" {
-				l.Add((NewURL(row["value"])))
+				if _, err := Canonicalize(row["value"]); err != nil {
+					verbose("url %q: %v", row["value"], err)
+					n := NewUnsupported(row["value"])
+					l.Add(n).addOrigin(n.H, rowOrigin(file, row))
+					continue
+				}
+				u := NewURL(canonURL(row["value"]))
+				l.Add(u).addOrigin(u.H, rowOrigin(file, row))
+				l.addThreat(u.H, row["indicator_threat_level"])
 			}
 		}
 	}
-
 	return l, nil
 }
 
+// csvColumns are the ones we need, with the UUIDs and threat level if the file has them
+func csvColumns(buf []byte) []string {
+	cols := []string{"type", "value", "to_ids"}
+	header, err := csv.NewReader(bytes.NewReader(buf)).Read()
+	if err != nil {
+		return cols
+	}
+	for _, h := range header {
+		switch h {
+		case "indicator_uuid", "observable_uuid", "indicator_threat_level":
+			cols = append(cols, h)
+		}
+	}
+	return cols
+}
+
+// rowOrigin is the indicator of the row, the observable if there is none
+func rowOrigin(file string, row csvplus.Row) Origin {
+	uuid := row["indicator_uuid"]
+	if uuid == "" {
+		uuid = row["observable_uuid"]
+	}
+	return Origin{File: file, UUID: uuid}
+}
+
+// addOrigin records where e comes from
+func (l *List) addOrigin(e string, all ...Origin) {
+	if l.origins == nil {
+		l.origins = NewResults()
+	}
+	l.origins.AddOrigin(e, all...)
+}
+
+// addThreat records the threat level of e, if the file has one
+func (l *List) addThreat(e, level string) {
+	if level == "" {
+		return
+	}
+	if l.origins == nil {
+		l.origins = NewResults()
+	}
+	l.origins.AddThreat(e, level)
+}
+
 func (l *List) Files() []string {
 	return l.files
 }
 
+// Results has every indicator of the list, without checking them
+func (l *List) Results() *Results {
+	r := NewResults()
+	for _, e := range l.s {
+		e.AddTo(r)
+	}
+	r.files = l.Files()
+	l.withOrigins(r)
+	return r
+}
+
+// withOrigins copies where the entries come from into r
+func (l *List) withOrigins(r *Results) {
+	if l.origins != nil {
+		for e, all := range l.origins.Origins {
+			r.AddOrigin(e, all...)
+		}
+		for e, level := range l.origins.Threats {
+			r.AddThreat(e, level)
+		}
+	}
+}
+
 func (l *List) Merge(l1 *List) *List {
 	for _, e := range l1.s {
 		l.Add(e)
 	}
+	if l1.origins != nil {
+		for e, all := range l1.origins.Origins {
+			l.addOrigin(e, all...)
+		}
+		for e, level := range l1.origins.Threats {
+			l.addThreat(e, level)
+		}
+	}
 	return l
 }
 
+// checkAll checks e against every proxy, true if any of them lets it through
+func checkAll(ctx *Context, e Sourcer) bool {
+	var keep bool
+
+	for _, p := range ctx.Proxies() {
+		if e.Check(ctx, p) {
+			keep = true
+		}
+	}
+	return keep
+}
+
 // Check1 (now reversed), is the initial implementation with a mutex
 func (l *List) Check1(ctx *Context) *Results {
 	var mut sync.Mutex
@@ -214,10 +560,10 @@ func (l *List) Check1(ctx *Context) *Results {
 
 	queue := make(chan Sourcer, len(l.s))
 
-	debug("setup %d workers\n", ctx.jobs)
+	debug("setup %d workers\n", ctx.workers())
 
 	// Setup workers
-	for i := 0; i < ctx.jobs; i++ {
+	for i := 0; i < ctx.workers(); i++ {
 		wg.Add(1)
 
 		go func(n int, wg *sync.WaitGroup) {
@@ -226,7 +572,7 @@ func (l *List) Check1(ctx *Context) *Results {
 			debug("%d is fine\n", n)
 			for e := range queue {
 				verbose("w%d - %d left", n, len(queue))
-				if e.Check(ctx.Client) {
+				if checkAll(ctx, e) {
 					verbose("adding %#v\n", e)
 					mut.Lock()
 					e.AddTo(r)
@@ -254,7 +600,7 @@ func res(ins <-chan Sourcer) *Results {
 	r := NewResults()
 
 	for e := range ins {
-		fmt.Print(".")
+		debug("result %v", e)
 		e.AddTo(r)
 	}
 	return r
@@ -277,10 +623,10 @@ func (l *List) Check(ctx *Context) *Results {
 	debug("setup done")
 
 	// Setup the end of the fan-out
-	debug("setup %d workers\n", ctx.jobs)
+	debug("setup %d workers\n", ctx.workers())
 
 	// Setup workers (fan-in)
-	for i := 0; i < ctx.jobs; i++ {
+	for i := 0; i < ctx.workers(); i++ {
 		wg.Add(1)
 
 		go func(n int, queue <-chan Sourcer, wg *sync.WaitGroup) {
@@ -294,7 +640,7 @@ func (l *List) Check(ctx *Context) *Results {
 				}
 
 				debug("w%d - checking %v", n, e)
-				if e.Check(ctx.Client) {
+				if checkAll(ctx, e) {
 					debug("adding %#v\n", e)
 					ins <- e
 				}
@@ -1,9 +1,13 @@
-package main
+package cimbl
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-resty/resty/v2"
@@ -42,7 +46,7 @@ func TestFilename_Check(t *testing.T) {
 	c := resty.New().SetProxy(proxy)
 
 	fn := NewFilename("example.docx")
-	assert.True(t, fn.Check(c))
+	assert.True(t, fn.Check(&Context{}, NewProxy(DefaultProxy, c)))
 }
 
 // URL
@@ -63,11 +67,20 @@ func TestURL_AddTo(t *testing.T) {
 	assert.Equal(t, td, r.URLs)
 }
 
+func TestURL_AddToHttps(t *testing.T) {
+	td := map[string]bool{"www.example.net": true}
+
+	u := NewURL("https://www.example.net/malware.exe")
+	r := NewResults()
+	u.AddTo(r)
+	assert.Empty(t, r.URLs)
+	assert.Equal(t, td, r.Domains)
+}
+
 func TestList_Check(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	assert.NotNil(t, config)
 
@@ -88,21 +101,20 @@ func TestList_Check(t *testing.T) {
 	defer gock.RestoreClient(c.GetClient())
 
 	u := NewURL(TestSite)
-	assert.True(t, u.Check(c))
+	assert.True(t, u.Check(&Context{}, NewProxy(DefaultProxy, c)))
 }
 
 func TestList_Check2(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	assert.NotNil(t, config)
 
 	ctx := &Context{
 		config: config,
-		jobs:   1,
+		opts:   Options{Jobs: 1},
 	}
 
 	l := NewList([]string{file})
@@ -139,16 +151,15 @@ func TestList_Check2(t *testing.T) {
 func TestList_Check3(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0666-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
-	fDebug = true
+	SetDebug(true)
 
 	ctx := &Context{
 		config: config,
-		jobs:   1,
+		opts:   Options{Jobs: 1},
 	}
 
 	l := NewList([]string{file})
@@ -182,20 +193,19 @@ func TestList_Check3(t *testing.T) {
 	assert.NoError(t, err, "no error")
 	assert.EqualValues(t, realPaths, res.Paths)
 	assert.EqualValues(t, realURLs, res.URLs)
-	fDebug = false
+	SetDebug(false)
 }
 
 func TestList_Check41(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   1,
+		opts:   Options{Jobs: 1},
 	}
 
 	l := NewList([]string{file})
@@ -232,14 +242,13 @@ func TestList_Check41(t *testing.T) {
 func TestList_Check43(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   3,
+		opts:   Options{Jobs: 3},
 	}
 
 	l := NewList([]string{file})
@@ -275,14 +284,13 @@ func TestList_Check43(t *testing.T) {
 func TestList_Check44(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   4,
+		opts:   Options{Jobs: 4},
 	}
 
 	l := NewList([]string{file})
@@ -318,14 +326,13 @@ func TestList_Check44(t *testing.T) {
 func TestList_Check48(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   8,
+		opts:   Options{Jobs: 8},
 	}
 
 	l := NewList([]string{file})
@@ -363,8 +370,7 @@ func TestList_Check48(t *testing.T) {
 func TestList_Check1(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	assert.NotNil(t, config)
 
@@ -385,21 +391,20 @@ func TestList_Check1(t *testing.T) {
 	defer gock.RestoreClient(c.GetClient())
 
 	u := NewURL(TestSite)
-	assert.True(t, u.Check(c))
+	assert.True(t, u.Check(&Context{}, NewProxy(DefaultProxy, c)))
 }
 
 func TestList_Check12(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 	assert.NotNil(t, config)
 
 	ctx := &Context{
 		config: config,
-		jobs:   1,
+		opts:   Options{Jobs: 1},
 	}
 
 	l := NewList([]string{file})
@@ -436,16 +441,15 @@ func TestList_Check12(t *testing.T) {
 func TestList_Check13(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0666-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
-	fDebug = true
+	SetDebug(true)
 
 	ctx := &Context{
 		config: config,
-		jobs:   1,
+		opts:   Options{Jobs: 1},
 	}
 
 	l := NewList([]string{file})
@@ -479,20 +483,19 @@ func TestList_Check13(t *testing.T) {
 	assert.NoError(t, err, "no error")
 	assert.EqualValues(t, realPaths, res.Paths)
 	assert.EqualValues(t, realURLs, res.URLs)
-	fDebug = false
+	SetDebug(false)
 }
 
 func TestList_Check141(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   1,
+		opts:   Options{Jobs: 1},
 	}
 
 	l := NewList([]string{file})
@@ -529,14 +532,13 @@ func TestList_Check141(t *testing.T) {
 func TestList_Check143(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   3,
+		opts:   Options{Jobs: 3},
 	}
 
 	l := NewList([]string{file})
@@ -572,14 +574,13 @@ func TestList_Check143(t *testing.T) {
 func TestList_Check144(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   4,
+		opts:   Options{Jobs: 4},
 	}
 
 	l := NewList([]string{file})
@@ -615,14 +616,13 @@ func TestList_Check144(t *testing.T) {
 func TestList_Check148(t *testing.T) {
 	defer gock.Off()
 
-	baseDir = "testdata"
 	file := "testdata/CIMBL-0669-CERTS.csv"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
 	ctx := &Context{
 		config: config,
-		jobs:   8,
+		opts:   Options{Jobs: 8},
 	}
 
 	l := NewList([]string{file})
@@ -699,6 +699,58 @@ func TestNewList_IP(t *testing.T) {
 	assert.EqualValues(t, td, l.s)
 }
 
+func TestList_AddFromIP_Rich(t *testing.T) {
+	td := []Sourcer{
+		NewURL("http://10.1.1.1/"),
+		NewURL("http://172.16.1.1:8080/"),
+		NewURL("http://192.168.1.1/"),
+		NewURL("http://[2001:db8::1]/"),
+		NewURL("http://[2001:db8::2]/"),
+		NewURL("http://[2001:db8::3]:8443/"),
+		NewURL("http://198.51.100.0/"),
+		NewURL("http://198.51.100.1/"),
+		NewURL("http://198.51.100.2/"),
+		NewURL("http://198.51.100.3/"),
+		NewNetwork("203.0.113.0/24"),
+	}
+
+	l := &List{opts: Options{IPExpand: 16}}
+	l, err := l.AddFromIP("testdata/iplist-rich.txt")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "3 invalid lines")
+	assert.Contains(t, err.Error(), "line 11")
+	assert.EqualValues(t, td, l.s)
+}
+
+func TestNetwork(t *testing.T) {
+	n := NewNetwork("203.0.113.0/24")
+	assert.True(t, n.Check(&Context{}, nil))
+
+	r := NewResults()
+	n.AddTo(r)
+	assert.Equal(t, map[string]bool{"203.0.113.0/24": true}, r.Networks)
+}
+
+func TestLoadList(t *testing.T) {
+	l, err := LoadList([]string{"testdata/CIMBL-0666-CERTS.csv", "/foo.bar", "testdata/nonexistent.txt"}, Options{})
+	require.Error(t, err)
+	assert.Equal(t, 2, l.Length())
+
+	lerr, ok := err.(*ListError)
+	require.True(t, ok)
+	require.Len(t, lerr.Inputs, 2)
+	assert.Equal(t, "/foo.bar", lerr.Inputs[0].Name)
+	assert.Equal(t, "testdata/nonexistent.txt", lerr.Inputs[1].Name)
+	assert.Contains(t, err.Error(), "2 bad inputs: /foo.bar: invalid filename")
+}
+
+func TestLoadList_Good(t *testing.T) {
+	l, err := LoadList([]string{"testdata/CIMBL-0666-CERTS.csv", "testdata/CIMBL-0667-CERTS.csv"}, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.Length())
+}
+
 func TestNewList_IPBad(t *testing.T) {
 	l := NewList([]string{"testdata/nonexistent.txt"})
 	assert.Empty(t, l)
@@ -762,6 +814,54 @@ func TestList_AddFromFile(t *testing.T) {
 	assert.EqualValues(t, l1, l)
 }
 
+func TestList_AddFromFile_Origins(t *testing.T) {
+	l := NewList(nil)
+	_, err := l.AddFromFile("testdata/CIMBL-0666-CERTS.csv")
+	require.NoError(t, err)
+
+	want := map[string][]Origin{
+		TestSite: {{File: "CIMBL-0666-CERTS.csv", UUID: "certeu:Indicator-59018120-2130-4e17-930b-1a67ac120003"}},
+	}
+	assert.Equal(t, want, l.Results().Origins)
+
+	// No indicator, the observable is used
+	l = NewList(nil)
+	_, err = l.ReadFromCSV(strings.NewReader("observable_uuid,kill_chain,type,value,to_ids\nobs-1,Delivery,url,http://www.example.com/,1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Origin{{File: "-", UUID: "obs-1"}}, l.Results().Origins["http://www.example.com/"])
+}
+
+func TestList_AddFromFile_Threats(t *testing.T) {
+	l := NewList(nil)
+	_, err := l.AddFromFile("testdata/CIMBL-0666-CERTS.csv")
+	require.NoError(t, err)
+
+	want := map[string]string{
+		"http://example.net/search.php":        "Medium",
+		"55fe62947f3860108e7798c4498618cb.rtf": "Medium",
+	}
+	assert.Equal(t, want, l.Results().Threats)
+}
+
+func TestList_ReadFromCSV_BadURL(t *testing.T) {
+	l := NewList(nil)
+	_, err := l.ReadFromCSV(strings.NewReader("type,value,to_ids\nurl,ftp://ftp.example.com/kit.zip,1\nurl,http://www.example.com/,1\n"))
+	require.NoError(t, err)
+	assert.EqualValues(t, []Sourcer{NewUnsupported("ftp://ftp.example.com/kit.zip"), NewURL("http://www.example.com/")}, l.s)
+
+	r := NewResults()
+	l.s[0].AddTo(r)
+	assert.Equal(t, map[string]bool{"ftp://ftp.example.com/kit.zip": true}, r.Unchecked)
+}
+
+func TestList_AddFromFile_Empty(t *testing.T) {
+	l := NewList(nil)
+	_, err := l.AddFromFile("testdata/CIMBL-0667-CERTS.csv")
+	require.NoError(t, err)
+	assert.Equal(t, 0, l.Length())
+	assert.Equal(t, []string{"CIMBL-0667-CERTS.csv"}, l.Files())
+}
+
 func TestList_AddFromFile_None(t *testing.T) {
 	l := NewList(nil)
 	l1, err := l.AddFromFile("/nonexistent")
@@ -810,7 +910,6 @@ func TestList_AddFromFile_Gpg(t *testing.T) {
 }
 
 func TestList_AddFromFile_Badcsv(t *testing.T) {
-
 	l := NewList(nil)
 	l1, err := l.AddFromFile("testdata/bad.csv")
 	require.Error(t, err)
@@ -840,3 +939,101 @@ func TestList_Merge(t *testing.T) {
 	assert.EqualValues(t, tdm, l2.s)
 	assert.EqualValues(t, tdm, l.s)
 }
+
+func TestList_CheckCancelled(t *testing.T) {
+	file := "testdata/CIMBL-0669-CERTS.csv"
+	config, err := LoadConfig("testdata/config.toml")
+	assert.NoError(t, err)
+
+	run, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ctx := &Context{
+		config: config,
+		opts:   Options{Jobs: 2},
+		Client: resty.New(),
+		run:    run,
+	}
+
+	l := NewList([]string{file})
+	require.NotEmpty(t, l)
+
+	realPaths := map[string]bool{
+		"55fe62947f3860108e7798c4498618cb.rtf": true,
+	}
+	realUnchecked := map[string]bool{
+		TestSite:                            true,
+		"http://www.example.net/search.php": true,
+	}
+
+	res := l.Check(ctx)
+	assert.EqualValues(t, realPaths, res.Paths)
+	assert.Empty(t, res.URLs)
+	assert.EqualValues(t, realUnchecked, res.Unchecked)
+}
+
+// newProxy fakes a proxy answering code to every request
+func newProxy(t *testing.T, name string, code int) (*Proxy, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	return NewProxy(name, resty.New().SetProxy(srv.URL)), srv
+}
+
+func TestList_CheckProxies(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	assert.NoError(t, err)
+
+	pa, sa := newProxy(t, "a", http.StatusForbidden)
+	defer sa.Close()
+	pb, sb := newProxy(t, "b", http.StatusOK)
+	defer sb.Close()
+
+	ctx := &Context{
+		config:  config,
+		opts:    Options{Jobs: 2},
+		proxies: []*Proxy{pa, pb},
+	}
+
+	l := NewList([]string{"testdata/CIMBL-0669-CERTS.csv"})
+	require.NotEmpty(t, l)
+
+	realURLs := map[string]bool{
+		TestSite:                            true,
+		"http://www.example.net/search.php": true,
+	}
+
+	res := l.Check(ctx)
+	assert.EqualValues(t, realURLs, res.URLs)
+	assert.Equal(t, []string{"a", "b"}, res.Proxies())
+	assert.Equal(t, ActionBlocked, res.Verdicts[TestSite]["a"].Action)
+	assert.Equal(t, TestSite, res.Verdicts[TestSite]["b"].Action)
+
+	urls, _ := res.PassedBy("a")
+	assert.Empty(t, urls)
+	urls, _ = res.PassedBy("b")
+	assert.EqualValues(t, realURLs, urls)
+}
+
+func TestList_CheckProxiesAllBlocked(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	assert.NoError(t, err)
+
+	pa, sa := newProxy(t, "a", http.StatusForbidden)
+	defer sa.Close()
+	pb, sb := newProxy(t, "b", http.StatusServiceUnavailable)
+	defer sb.Close()
+
+	ctx := &Context{
+		config:  config,
+		opts:    Options{Jobs: 2},
+		proxies: []*Proxy{pa, pb},
+	}
+
+	l := NewList([]string{"testdata/CIMBL-0669-CERTS.csv"})
+	require.NotEmpty(t, l)
+
+	res := l.Check(ctx)
+	assert.Empty(t, res.URLs)
+	assert.NotEmpty(t, res.Paths)
+}
@@ -0,0 +1,202 @@
+package cimbl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ResultsVersion is the version of the file format, files from newer versions are refused
+const ResultsVersion = 1
+
+// Saved is the file format of the results, with what is not in Results itself
+type Saved struct {
+	// Name is the name in the store, if any
+	Name string `json:"-"`
+
+	Version int
+	Time    time.Time
+	Files   []string
+	// Failed are the inputs we could not read
+	Failed  string `json:",omitempty"`
+	Results *Results
+}
+
+// WriteResults writes r in the current format
+func WriteResults(w io.Writer, r *Results) error {
+	saved := Saved{
+		Version: ResultsVersion,
+		Time:    time.Now(),
+		Files:   r.files,
+		Results: r,
+	}
+	if r.failed != nil {
+		saved.Failed = r.failed.Error()
+	}
+
+	buf, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadResults reads results written by WriteResults, the files and failed inputs being put back
+func ReadResults(rd io.Reader) (*Saved, error) {
+	saved := &Saved{}
+	if err := json.NewDecoder(rd).Decode(saved); err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+	if saved.Version > ResultsVersion {
+		return nil, fmt.Errorf("unsupported version %d, %d at most", saved.Version, ResultsVersion)
+	}
+
+	if saved.Results == nil {
+		saved.Results = NewResults()
+	}
+	saved.Results.fill()
+	saved.Results.files = saved.Files
+	if saved.Failed != "" {
+		saved.Results.failed = errors.New(saved.Failed)
+	}
+	return saved, nil
+}
+
+// SaveResults writes r into file, replacing it only once complete
+func SaveResults(file string, r *Results) error {
+	tmp, err := writeTemp(filepath.Dir(file), r)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "results/rename")
+	}
+	return nil
+}
+
+// writeTemp writes r into a new temporary file of dir, not seen by the store
+func writeTemp(dir string, r *Results) (string, error) {
+	fh, err := ioutil.TempFile(dir, ".results")
+	if err != nil {
+		return "", errors.Wrap(err, "results/create")
+	}
+
+	if err := WriteResults(fh, r); err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return "", errors.Wrap(err, "results/write")
+	}
+	if err := fh.Close(); err != nil {
+		os.Remove(fh.Name())
+		return "", errors.Wrap(err, "results/close")
+	}
+	return fh.Name(), nil
+}
+
+// LoadResults reads results saved in file
+func LoadResults(file string) (*Saved, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "results/open")
+	}
+	defer fh.Close()
+
+	saved, err := ReadResults(fh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "results/%s", file)
+	}
+	return saved, nil
+}
+
+// Store keeps the results of every run, one file each, so they can be reported later
+type Store struct {
+	Dir string
+}
+
+// StoreExt is the extension of the saved results
+const StoreExt = ".json"
+
+// NewStore uses dir, created when needed
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Save writes r into a new entry named after the time of the run, it only appears once complete
+func (s *Store) Save(r *Results) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return "", errors.Wrap(err, "store")
+	}
+
+	tmp, err := writeTemp(s.Dir, r)
+	if err != nil {
+		return "", errors.Wrap(err, "store")
+	}
+	defer os.Remove(tmp)
+
+	// Several runs in the same second get a suffix, a link never replaces an entry
+	base := time.Now().Format("20060102-150405")
+	name := base
+	for i := 1; ; i++ {
+		err := os.Link(tmp, filepath.Join(s.Dir, name+StoreExt))
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%s-%d", base, i)
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "store/link")
+		}
+		return name, nil
+	}
+}
+
+// Names returns the saved runs, oldest first
+func (s *Store) Names() ([]string, error) {
+	all, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, errors.Wrap(err, "store")
+	}
+
+	names := []string{}
+	for _, fi := range all {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), StoreExt) {
+			names = append(names, strings.TrimSuffix(fi.Name(), StoreExt))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load reads the run called name, the last one if name is empty
+func (s *Store) Load(name string) (*Saved, error) {
+	if name == "" {
+		names, err := s.Names()
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no saved results in %s", s.Dir)
+		}
+		name = names[len(names)-1]
+	}
+
+	name = strings.TrimSuffix(name, StoreExt)
+	saved, err := LoadResults(filepath.Join(s.Dir, name+StoreExt))
+	if err != nil {
+		return nil, errors.Wrap(err, "store")
+	}
+	saved.Name = name
+	return saved, nil
+}
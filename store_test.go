@@ -0,0 +1,144 @@
+package cimbl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_SaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := NewStore(filepath.Join(dir, "results"))
+
+	r := NewResults()
+	r.files = []string{"CIMBL-0666-CERTS.csv"}
+	r.Add("url", "http://example.com/malware")
+	r.AddVerdict("http://example.com/malware", DefaultProxy, Verdict{Action: "http://example.com/malware", Method: "HEAD", Code: 200})
+
+	first, err := s.Save(r)
+	require.NoError(t, err)
+	second, err := s.Save(NewResults())
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	names, err := s.Names()
+	require.NoError(t, err)
+	assert.Equal(t, []string{first, second}, names)
+
+	// No temporary file left behind
+	all, err := ioutil.ReadDir(s.Dir)
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	saved, err := s.Load(first)
+	require.NoError(t, err)
+	assert.Equal(t, first, saved.Name)
+	assert.Equal(t, r.files, saved.Files)
+	assert.Equal(t, r.files, saved.Results.Files())
+	assert.Equal(t, r.URLs, saved.Results.URLs)
+	assert.Equal(t, r.Verdicts, saved.Results.Verdicts)
+
+	// Last one by default
+	saved, err = s.Load("")
+	require.NoError(t, err)
+	assert.Equal(t, second, saved.Name)
+	assert.Empty(t, saved.Results.URLs)
+}
+
+func TestStore_Empty(t *testing.T) {
+	s := NewStore("/nonexistent")
+
+	names, err := s.Names()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	_, err = s.Load("")
+	assert.Error(t, err)
+}
+
+func TestStore_LoadBad(t *testing.T) {
+	s := NewStore("testdata")
+
+	_, err := s.Load("nonexistent")
+	assert.Error(t, err)
+
+	_, err = s.Load("indicators")
+	assert.Error(t, err)
+}
+
+func TestStore_SaveBadDir(t *testing.T) {
+	_, err := NewStore("testdata/config.toml/results").Save(NewResults())
+	assert.Error(t, err)
+}
+
+func TestList_Results(t *testing.T) {
+	l := NewList([]string{"testdata/CIMBL-0666-CERTS.csv"})
+
+	r := l.Results()
+	assert.Equal(t, map[string]bool{"http://example.net/search.php": true}, r.URLs)
+	assert.Equal(t, map[string]bool{"55fe62947f3860108e7798c4498618cb.rtf": true}, r.Paths)
+	assert.Equal(t, []string{"CIMBL-0666-CERTS.csv"}, r.Files())
+}
+
+func TestSaveLoadResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "results.json")
+
+	r := NewResults()
+	r.files = []string{"CIMBL-0666-CERTS.csv"}
+	r.failed = errors.New("/foo.bar: no such file")
+	r.Meta = Metadata{Program: "erc-cimbl/test", Inputs: []string{"CIMBL-0666-CERTS.csv", "/foo.bar"}, Proxies: []string{DefaultProxy}}
+	r.Add("url", "http://example.com/malware")
+	r.AddVerdict("http://example.com/malware", DefaultProxy, Verdict{Action: "http://example.com/malware", Method: "HEAD", Code: 200})
+
+	require.NoError(t, SaveResults(file, r))
+
+	saved, err := LoadResults(file)
+	require.NoError(t, err)
+	assert.Equal(t, ResultsVersion, saved.Version)
+	assert.Equal(t, r.files, saved.Results.Files())
+	assert.EqualError(t, saved.Results.Failed(), r.failed.Error())
+	assert.Equal(t, r.Meta, saved.Results.Meta)
+	assert.Equal(t, r.Verdicts, saved.Results.Verdicts)
+}
+
+func TestLoadResultsBad(t *testing.T) {
+	_, err := LoadResults("/nonexistent/results.json")
+	assert.Error(t, err)
+
+	_, err = LoadResults("testdata/config.toml")
+	assert.Error(t, err)
+}
+
+func TestSaveResultsBadDir(t *testing.T) {
+	assert.Error(t, SaveResults("/nonexistent/results.json", NewResults()))
+}
+
+func TestReadResults_Version(t *testing.T) {
+	_, err := ReadResults(strings.NewReader(`{"Version": 666}`))
+	assert.Error(t, err)
+
+	// Before versions, maps may be missing
+	saved, err := ReadResults(strings.NewReader(`{"Files": ["a.csv"], "Results": {"URLs": {"http://example.com/": true}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, 0, saved.Version)
+	assert.Equal(t, []string{"a.csv"}, saved.Results.Files())
+	assert.NotNil(t, saved.Results.Verdicts)
+	assert.Nil(t, saved.Results.Failed())
+
+	saved.Results.Add("domain", "example.com")
+	assert.Len(t, saved.Results.Domains, 1)
+}
@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"regexp"
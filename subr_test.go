@@ -1,4 +1,4 @@
-package main
+package cimbl
 
 import (
 	"testing"
@@ -10,11 +10,10 @@ import (
 )
 
 func TestCheckFilename(t *testing.T) {
-	baseDir = "testdata"
-	config, err := loadConfig()
+	config, err := LoadConfig("testdata/config.toml")
 	assert.NoError(t, err)
 
-	fVerbose = true
+	SetVerbose(true)
 
 	snd, err := sandbox.New("test")
 	require.NoError(t, err)
@@ -0,0 +1,305 @@
+package cimbl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateConfig points at the report templates, the built-in one is used if there is no text one
+//
+// [template]
+// text = "/etc/erc-cimbl/report.tmpl"
+// html = "/etc/erc-cimbl/report.html"
+type TemplateConfig struct {
+	// Text is a text/template for the body of the mail
+	Text string
+	// HTML is a html/template, the mail then has both parts
+	HTML string
+}
+
+// ReportData is what the templates get
+type ReportData struct {
+	From    string
+	To      string
+	Cc      string
+	Subject string
+
+	MyName    string
+	MyVersion string
+
+	// Files are the files read, joined by commas, FileList the same one by one
+	Files    string
+	FileList []string
+	// Failed are the inputs we could not read, empty if none
+	Failed string
+	// Meta describes the run
+	Meta Metadata
+
+	// Indicators are per type, see IndicatorTypes, in the report order
+	Indicators map[string][]Indicator
+	// Counts are the number of indicators per type
+	Counts map[string]int
+	// Proxies are what each proxy still lets through, only when there are several
+	Proxies []ProxyReport
+
+	// The paragraphs of the built-in template, already formatted
+	URLs       string
+	Domains    string
+	Aggregated string
+	Networks   string
+	Paths      string
+	Hashes     string
+	DNS        string
+	Unchecked  string
+	Allowed    string
+
+	defang bool
+}
+
+// Indicator is one line of the report
+type Indicator struct {
+	Value string
+	// Verdicts are per proxy, for URLs only
+	Verdicts map[string]Verdict
+	// Note is the number of URLs of an aggregated domain or why an entry is allowed
+	Note string
+}
+
+// ProxyReport is what one proxy still lets through
+type ProxyReport struct {
+	Name    string
+	URLs    []string
+	Domains []string
+}
+
+// IndicatorTypes are the keys of ReportData.Indicators, in the order of the built-in report
+var IndicatorTypes = []string{"url", "domain", "aggregated", "network", "filename", "hash", "dns", "unchecked", "allowed"}
+
+// Show defangs str if the report is to be defanged
+func (d *ReportData) Show(str string) string {
+	if d.defang {
+		return Defang(str)
+	}
+	return str
+}
+
+// templateFuncs are the helpers available in both kinds of templates
+var templateFuncs = map[string]interface{}{
+	"defang": Defang,
+	"join":   strings.Join,
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+	"host":   urlHost,
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+}
+
+// urlHost returns the host of an URL, str itself if it is not one
+func urlHost(str string) string {
+	u, err := url.Parse(str)
+	if err != nil || u.Host == "" {
+		return str
+	}
+	return u.Hostname()
+}
+
+// reportTemplates are the compiled templates
+type reportTemplates struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+var builtinTemplates = &reportTemplates{
+	text: template.Must(template.New("mail").Funcs(templateFuncs).Parse(mailTmpl)),
+}
+
+// loadTemplates reads the templates of the configuration, the built-in one if none
+func loadTemplates(tc *TemplateConfig) (*reportTemplates, error) {
+	if tc == nil || (tc.Text == "" && tc.HTML == "") {
+		return builtinTemplates, nil
+	}
+
+	t := &reportTemplates{text: builtinTemplates.text}
+	if tc.Text != "" {
+		buf, err := ioutil.ReadFile(tc.Text)
+		if err != nil {
+			return nil, errors.Wrap(err, "text")
+		}
+		t.text, err = template.New("mail").Funcs(templateFuncs).Parse(string(buf))
+		if err != nil {
+			return nil, errors.Wrap(err, "text")
+		}
+	}
+	if tc.HTML != "" {
+		buf, err := ioutil.ReadFile(tc.HTML)
+		if err != nil {
+			return nil, errors.Wrap(err, "html")
+		}
+		t.html, err = htmltemplate.New("mail").Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(string(buf))
+		if err != nil {
+			return nil, errors.Wrap(err, "html")
+		}
+	}
+	return t, nil
+}
+
+// newReportData gathers everything the templates may use
+func newReportData(ctx *Context, res *Results) *ReportData {
+	d := &ReportData{
+		From:       ctx.config.From,
+		To:         ctx.config.To,
+		Cc:         ctx.config.Cc,
+		Subject:    ctx.config.Subject,
+		MyName:     MyName,
+		MyVersion:  MyVersion,
+		Files:      strings.Join(res.files, ", "),
+		FileList:   res.files,
+		Meta:       res.Meta,
+		Indicators: map[string][]Indicator{},
+		Counts:     map[string]int{},
+		Paths:      addPaths(ctx, res),
+		Hashes:     addHashes(ctx, res),
+		URLs:       addURLs(ctx, res),
+		Domains:    addDomains(ctx, res),
+		Aggregated: addAggregated(ctx, res),
+		Networks:   addNetworks(ctx, res),
+		DNS:        addDNS(ctx, res),
+		Unchecked:  addUnchecked(ctx, res),
+		Allowed:    addAllowed(ctx, res),
+		defang:     ctx.opts.Defang,
+	}
+	if res.failed != nil {
+		d.Failed = res.failed.Error()
+	}
+
+	add := func(t string, keys []string, note func(string) string) {
+		for _, k := range ctx.sorted(res, keys) {
+			d.Indicators[t] = append(d.Indicators[t], Indicator{Value: k, Verdicts: res.Verdicts[k], Note: note(k)})
+		}
+		d.Counts[t] = len(d.Indicators[t])
+	}
+	none := func(string) string { return "" }
+
+	if !ctx.opts.NoURLs {
+		add("url", keysOf(res.URLs), none)
+		add("domain", keysOf(res.Domains), none)
+		add("network", keysOf(res.Networks), none)
+		add("aggregated", aggregatedKeys(res), func(k string) string {
+			return fmt.Sprintf("%d URLs", len(res.Aggregated[k]))
+		})
+		add("dns", resolving(res), none)
+	}
+	if !ctx.opts.NoPaths {
+		add("filename", keysOf(res.Paths), none)
+		add("hash", keysOf(res.Hashes), none)
+	}
+	add("unchecked", keysOf(res.Unchecked), none)
+	add("allowed", allowedKeys(res), func(k string) string { return res.Allowed[k] })
+
+	// One block request per proxy if we have several
+	if names := res.Proxies(); len(names) > 1 {
+		for _, name := range names {
+			urls, domains := res.PassedBy(name)
+			d.Proxies = append(d.Proxies, ProxyReport{
+				Name:    name,
+				URLs:    ctx.sorted(res, keysOf(urls)),
+				Domains: ctx.sorted(res, keysOf(domains)),
+			})
+		}
+		d.URLs = addPerProxy(ctx, res, names)
+		d.Domains = addIndicatorDomains(ctx, res)
+		d.Aggregated = ""
+	}
+	return d
+}
+
+// render runs the templates, the mail having both parts if there is a HTML one
+func (t *reportTemplates) render(d *ReportData) (string, error) {
+	var txt bytes.Buffer
+
+	headers := fmt.Sprintf("Subject: %s\nTo: %s\nCc: %s\nX-Contact-Info: %s\n", d.Subject, d.To, d.Cc, d.From)
+
+	if t.html == nil {
+		txt.WriteString(headers + "\n")
+		if err := t.text.Execute(&txt, d); err != nil {
+			return "", errors.Wrap(err, "text")
+		}
+		return txt.String(), nil
+	}
+
+	var plain, html bytes.Buffer
+	if err := t.text.Execute(&plain, d); err != nil {
+		return "", errors.Wrap(err, "text")
+	}
+	if err := t.html.Execute(&html, d); err != nil {
+		return "", errors.Wrap(err, "html")
+	}
+
+	// The boundary comes from the parts so that the same report gives the same mail
+	sum := sha256.Sum256(append(plain.Bytes(), html.Bytes()...))
+	mw := multipart.NewWriter(&txt)
+	if err := mw.SetBoundary(fmt.Sprintf("cimbl-%x", sum[:16])); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&txt, "%sMIME-Version: 1.0\nContent-Type: multipart/alternative; boundary=%s\n\n", headers, mw.Boundary())
+
+	for _, part := range []struct {
+		ctype string
+		body  []byte
+	}{
+		{"text/plain; charset=utf-8", plain.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.ctype}})
+		if err != nil {
+			return "", err
+		}
+		pw.Write(part.body)
+	}
+	err := mw.Close()
+	return txt.String(), err
+}
+
+// Report renders the mail for res, headers included
+func (ctx *Context) Report(res *Results) (string, error) {
+	return createMail(ctx, res)
+}
+
+// SampleResults are made-up results with every kind of indicator, to try templates with
+func SampleResults() *Results {
+	r := NewResults()
+	r.files = []string{"CIMBL-0666-CERTS.csv", "CIMBL-0667-CERTS.zip"}
+	r.failed = errors.New("CIMBL-0668-CERTS.zip: bad signature")
+	r.Meta = Metadata{
+		Program:  MyName + "/" + MyVersion,
+		Started:  time.Date(2019, time.July, 1, 10, 0, 0, 0, time.UTC),
+		Duration: 90 * time.Second,
+		Inputs:   []string{"CIMBL-0666-CERTS.csv", "CIMBL-0667-CERTS.zip", "CIMBL-0668-CERTS.zip"},
+		Proxies:  []string{DefaultProxy},
+	}
+
+	r.Add("url", "http://example.com/malware")
+	r.AddVerdict("http://example.com/malware", DefaultProxy, Verdict{Action: "http://example.com/malware", Method: "HEAD", Code: 200})
+	r.Add("domain", "secure.example.net")
+	r.AddVerdict("https://secure.example.net/login", DefaultProxy, Verdict{Action: "https://secure.example.net/login", Method: "CONNECT", Code: 200})
+	r.AddAggregated("example.org", "http://www.example.org/a", "http://www.example.org/b")
+	r.Add("network", "192.0.2.0/24")
+	r.Add("filename", "invoice.docm")
+	r.Add("hash", "0123456789abcdef0123456789abcdef")
+	r.AddDNS("c2.example.com", DNSResolves)
+	r.Add("unchecked", "http://slow.example.com/payload")
+	r.AddAllowed("www.microsoft.com", "domain microsoft.com")
+	return r
+}
@@ -0,0 +1,144 @@
+package cimbl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTemplatesNone(t *testing.T) {
+	tmpl, err := loadTemplates(nil)
+	require.NoError(t, err)
+	assert.Equal(t, builtinTemplates, tmpl)
+
+	tmpl, err = loadTemplates(&TemplateConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, builtinTemplates, tmpl)
+}
+
+func TestLoadTemplatesBad(t *testing.T) {
+	_, err := loadTemplates(&TemplateConfig{Text: "/nonexistent"})
+	assert.Error(t, err)
+
+	_, err = loadTemplates(&TemplateConfig{HTML: "/nonexistent"})
+	assert.Error(t, err)
+
+	_, err = loadTemplates(&TemplateConfig{Text: "testdata/broken.tmpl"})
+	assert.Error(t, err)
+
+	_, err = loadTemplates(&TemplateConfig{HTML: "testdata/broken.tmpl"})
+	assert.Error(t, err)
+}
+
+func TestNewContextTemplate(t *testing.T) {
+	config, err := LoadConfig("testdata/config-template.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	assert.NotNil(t, ctx.getTemplates().html)
+
+	config.Template.Text = "testdata/broken.tmpl"
+	_, err = NewContext(config, Options{})
+	assert.Error(t, err)
+}
+
+func TestReport_Builtin(t *testing.T) {
+	config, err := LoadConfig("testdata/config.toml")
+	require.NoError(t, err)
+	ctx := &Context{config: config}
+
+	txt, err := ctx.Report(SampleResults())
+	require.NoError(t, err)
+	assert.Contains(t, txt, "Subject: "+config.Subject+"\n")
+	assert.Contains(t, txt, "\n\nDear Service Desk,\n")
+	assert.Contains(t, txt, urlsTmpl+"  http://example.com/malware\n")
+	assert.Contains(t, txt, allowedTmpl)
+	assert.NotContains(t, txt, "MIME-Version")
+}
+
+func TestReport_Text(t *testing.T) {
+	tmpl, err := loadTemplates(&TemplateConfig{Text: "testdata/report.tmpl"})
+	require.NoError(t, err)
+	ctx := &Context{config: &Config{Subject: "CIMBL"}, opts: Options{Defang: true}, tmpl: tmpl}
+
+	txt, err := ctx.Report(SampleResults())
+	require.NoError(t, err)
+	assert.Contains(t, txt, "Subject: CIMBL\n")
+	assert.Contains(t, txt, "1 URLs from CIMBL-0666-CERTS.csv, CIMBL-0667-CERTS.zip ("+MyName+"/"+MyVersion+", 2019-07-01):\n")
+	assert.Contains(t, txt, "  hxxp://example[.]com/malware on example.com [default: HEAD 200]\n")
+	assert.Contains(t, txt, "  example.org (2 URLs)\n")
+	assert.Contains(t, txt, "Not read: CIMBL-0668-CERTS.zip: bad signature\n")
+	assert.NotContains(t, txt, "Dear Service Desk")
+}
+
+func TestReport_Missing(t *testing.T) {
+	tmpl, err := loadTemplates(&TemplateConfig{Text: "testdata/bad.tmpl"})
+	require.NoError(t, err)
+	ctx := &Context{config: &Config{}, tmpl: tmpl}
+
+	txt, err := ctx.Report(SampleResults())
+	assert.Error(t, err)
+	assert.Empty(t, txt)
+}
+
+func TestReport_HTML(t *testing.T) {
+	config, err := LoadConfig("testdata/config-template.toml")
+	require.NoError(t, err)
+	tmpl, err := loadTemplates(config.Template)
+	require.NoError(t, err)
+	ctx := &Context{config: config, tmpl: tmpl}
+
+	txt, err := ctx.Report(SampleResults())
+	require.NoError(t, err)
+	assert.Contains(t, txt, "MIME-Version: 1.0\nContent-Type: multipart/alternative; boundary=")
+	assert.Contains(t, txt, "Content-Type: text/plain; charset=utf-8")
+	assert.Contains(t, txt, "Content-Type: text/html; charset=utf-8")
+	assert.Contains(t, txt, "<li>http://example.com/malware</li>")
+}
+
+func TestNewReportData(t *testing.T) {
+	ctx := &Context{config: &Config{}}
+	res := SampleResults()
+	res.Add("url", "http://a.example.com/first")
+
+	d := newReportData(ctx, res)
+	require.Len(t, d.Indicators["url"], 2)
+	assert.Equal(t, "http://a.example.com/first", d.Indicators["url"][0].Value)
+	assert.Equal(t, 200, d.Indicators["url"][1].Verdicts[DefaultProxy].Code)
+	assert.Equal(t, 2, d.Counts["url"])
+	assert.Equal(t, "domain microsoft.com", d.Indicators["allowed"][0].Note)
+	assert.Equal(t, []string{"c2.example.com"}, []string{d.Indicators["dns"][0].Value})
+	for _, typ := range IndicatorTypes {
+		assert.NotEmpty(t, d.Indicators[typ], typ)
+	}
+	assert.Empty(t, d.Proxies)
+
+	// What we do not check is not reported
+	ctx.opts = Options{NoURLs: true, NoPaths: true}
+	d = newReportData(ctx, res)
+	assert.Empty(t, d.Indicators["url"])
+	assert.Empty(t, d.Indicators["hash"])
+	assert.Len(t, d.Indicators["unchecked"], 1)
+}
+
+func TestNewReportDataPerProxy(t *testing.T) {
+	res := NewResults()
+	res.Add("url", "http://example.com/malware")
+	res.AddVerdict("http://example.com/malware", "brussels", Verdict{Action: ActionBlocked})
+	res.AddVerdict("http://example.com/malware", "bretigny", Verdict{Action: "http://example.com/malware"})
+
+	d := newReportData(&Context{config: &Config{}}, res)
+	assert.Equal(t, []ProxyReport{
+		{Name: "bretigny", URLs: []string{"http://example.com/malware"}, Domains: []string{}},
+		{Name: "brussels", URLs: []string{}, Domains: []string{}},
+	}, d.Proxies)
+}
+
+func TestURLHost(t *testing.T) {
+	assert.Equal(t, "example.com", urlHost("http://example.com:8080/malware"))
+	assert.Equal(t, "example.com", urlHost("example.com"))
+}
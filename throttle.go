@@ -0,0 +1,153 @@
+package cimbl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTimeout is for each request
+	DefaultTimeout = 10 * time.Second
+	// DefaultBackoff is the first wait before retrying, doubled each time
+	DefaultBackoff = 500 * time.Millisecond
+)
+
+// Throttle keeps us from hammering the proxy: global rate, concurrency per host and retries.
+type Throttle struct {
+	Retries int
+	Backoff time.Duration
+
+	interval time.Duration
+	perHost  int
+
+	mu    sync.Mutex
+	next  time.Time
+	hosts map[string]chan struct{}
+}
+
+// NewThrottle creates a throttle, rate is in requests/s, 0 means no limit
+func NewThrottle(rate float64, perHost, retries int, backoff time.Duration) *Throttle {
+	t := &Throttle{
+		Retries: retries,
+		Backoff: backoff,
+		perHost: perHost,
+		hosts:   map[string]chan struct{}{},
+	}
+	if rate > 0 {
+		t.interval = time.Duration(float64(time.Second) / rate)
+	}
+	if t.Backoff == 0 {
+		t.Backoff = DefaultBackoff
+	}
+	return t
+}
+
+// wait until we are allowed to send another request
+func (t *Throttle) wait(ctx context.Context) error {
+	if t.interval == 0 {
+		return ctx.Err()
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	d := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+
+	return sleep(ctx, d)
+}
+
+// sleep for d unless cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tm.C:
+		return nil
+	}
+}
+
+// Acquire waits for a slot on host and returns the function releasing it
+func (t *Throttle) Acquire(ctx context.Context, host string) (func(), error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, err
+	}
+	if t.perHost <= 0 {
+		return func() {}, nil
+	}
+
+	t.mu.Lock()
+	sem, ok := t.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, t.perHost)
+		t.hosts[host] = sem
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	}
+}
+
+// retryable is for timeouts and gateway errors.  503 is not retried as it is
+// how many proxies say "blocked".
+func retryable(a answer, err error) bool {
+	if err != nil {
+		if ne, ok := errors.Cause(err).(net.Error); ok && ne.Timeout() {
+			return true
+		}
+		return false
+	}
+	switch a.Code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Do runs probe against host within the limits, retrying with exponential backoff.
+// A nil throttle runs it once.
+func (t *Throttle) Do(ctx context.Context, host string, probe func() (answer, error)) (answer, error) {
+	var (
+		a       answer
+		err     error
+		release func()
+	)
+
+	if t == nil {
+		return probe()
+	}
+
+	wait := t.Backoff
+	for try := 0; ; try++ {
+		release, err = t.Acquire(ctx, host)
+		if err != nil {
+			return answer{}, err
+		}
+		a, err = probe()
+		release()
+
+		if try >= t.Retries || !retryable(a, err) {
+			return a, err
+		}
+		verbose("retrying %s in %v (%d/%d)", host, wait, try+1, t.Retries)
+		if err := sleep(ctx, wait); err != nil {
+			return a, err
+		}
+		wait *= 2
+	}
+}
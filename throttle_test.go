@@ -0,0 +1,174 @@
+package cimbl
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigPolite(t *testing.T) {
+	c, err := LoadConfig("testdata/config-polite.toml")
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, c.Rate)
+	assert.Equal(t, 2, c.PerHost)
+	assert.Equal(t, 3, c.Retries)
+	assert.Equal(t, 100*time.Millisecond, c.Backoff.Duration)
+	assert.Equal(t, 30*time.Second, c.Timeout.Duration)
+}
+
+func TestNewContextPolite(t *testing.T) {
+	config, err := LoadConfig("testdata/config-polite.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{})
+	require.NoError(t, err)
+	defer ctx.Cleanup()
+
+	assert.Equal(t, 30*time.Second, ctx.Client.GetClient().Timeout)
+	assert.Equal(t, 3, ctx.throttle.Retries)
+	assert.Equal(t, 200*time.Millisecond, ctx.throttle.interval)
+}
+
+func TestNewThrottle(t *testing.T) {
+	th := NewThrottle(0, 0, 0, 0)
+	assert.Zero(t, th.interval)
+	assert.Equal(t, DefaultBackoff, th.Backoff)
+
+	th = NewThrottle(4, 1, 2, time.Second)
+	assert.Equal(t, 250*time.Millisecond, th.interval)
+	assert.Equal(t, time.Second, th.Backoff)
+}
+
+func TestThrottle_Rate(t *testing.T) {
+	th := NewThrottle(20, 0, 0, 0)
+
+	t1 := time.Now()
+	for i := 0; i < 5; i++ {
+		release, err := th.Acquire(context.Background(), "example.com")
+		require.NoError(t, err)
+		release()
+	}
+	// First one is free
+	assert.True(t, time.Since(t1) >= 4*50*time.Millisecond)
+}
+
+func TestThrottle_PerHost(t *testing.T) {
+	var cur, max int32
+
+	th := NewThrottle(0, 2, 0, 0)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release, _ := th.Acquire(context.Background(), "example.com")
+			n := atomic.AddInt32(&cur, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&cur, -1)
+			release()
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 2, max)
+}
+
+func TestThrottle_DoRetry(t *testing.T) {
+	defer gock.Off()
+
+	ctx := &Context{throttle: NewThrottle(0, 0, 2, time.Millisecond)}
+
+	c := resty.New()
+
+	testSite, err := url.Parse(TestSite)
+	require.NoError(t, err)
+
+	gock.New(testSite.Host).
+		Head(testSite.Path).
+		Reply(502)
+	gock.New(testSite.Host).
+		Head(testSite.Path).
+		Reply(403)
+
+	gock.InterceptClient(c.GetClient())
+	defer gock.RestoreClient(c.GetClient())
+
+	u, err := handleURL(ctx, c, TestSite)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionBlocked, u)
+	assert.True(t, gock.IsDone())
+}
+
+func TestThrottle_DoGiveUp(t *testing.T) {
+	var n int
+
+	th := NewThrottle(0, 0, 2, time.Millisecond)
+
+	a, err := th.Do(context.Background(), "example.com", func() (answer, error) {
+		n++
+		return answer{Code: 504}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 504, a.Code)
+	assert.Equal(t, 3, n)
+}
+
+func TestThrottle_DoNoRetry(t *testing.T) {
+	var n int
+
+	th := NewThrottle(0, 0, 2, time.Millisecond)
+
+	a, err := th.Do(context.Background(), "example.com", func() (answer, error) {
+		n++
+		return answer{Code: 503}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 503, a.Code)
+	assert.Equal(t, 1, n)
+}
+
+func TestThrottle_AcquireCancelled(t *testing.T) {
+	th := NewThrottle(0, 1, 0, 0)
+
+	release, err := th.Acquire(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	r, err := th.Acquire(ctx, "example.com")
+	assert.Error(t, err)
+	assert.Nil(t, r)
+	release()
+}
+
+func TestThrottle_DoCancelled(t *testing.T) {
+	var n int
+
+	th := NewThrottle(0, 0, 5, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := th.Do(ctx, "example.com", func() (answer, error) {
+		n++
+		return answer{Code: 502}, nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,324 @@
+package cimbl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+// TicketConfig describes the REST/JSON webhook opening change requests
+//
+// [ticket]
+// url = "https://servicedesk.example.com/api/change"
+// header = "Authorization"
+// token = "Bearer xxx"
+// id = "result.number"
+// state = "/var/lib/erc-cimbl/tickets.json"
+type TicketConfig struct {
+	URL string
+	// Header & Token are the authentication, "Authorization" by default
+	Header string
+	Token  string
+	// Template is the file with the JSON body, DefaultTicketTmpl if empty
+	Template string
+	// ID is the dotted path of the ticket number in the answer, if any
+	ID string
+	// State records the tickets already opened and their indicators, so that reruns only
+	// open one for the new indicators
+	State   string
+	Timeout Duration
+}
+
+// DefaultTicketTmpl is the body if there is no template
+const DefaultTicketTmpl = `{
+  "title": {{json .Subject}},
+  "description": {{json .Report}},
+  "key": {{json .Key}}
+}
+`
+
+// TicketData is what the ticket template gets, the report data with the key and the text of the mail
+type TicketData struct {
+	*ReportData
+	// Key is the idempotency key of the indicators
+	Key string
+	// Report is the body of the mail
+	Report string
+}
+
+// Ticketer opens a change request, it is to a ticketing system what MailSender is to mail
+type Ticketer interface {
+	Open(key string, body []byte) (string, error)
+}
+
+// WebhookTicketer POSTs the request to an URL, the key is sent as Idempotency-Key
+type WebhookTicketer struct {
+	Client *resty.Client
+	URL    string
+	Header string
+	Token  string
+	ID     string
+}
+
+// NewWebhookTicketer uses the configuration
+func NewWebhookTicketer(tc TicketConfig) *WebhookTicketer {
+	timeout := tc.Timeout.Duration
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	header := tc.Header
+	if header == "" {
+		header = "Authorization"
+	}
+	return &WebhookTicketer{
+		Client: resty.New().SetTimeout(timeout),
+		URL:    tc.URL,
+		Header: header,
+		Token:  tc.Token,
+		ID:     tc.ID,
+	}
+}
+
+// Open creates the ticket and returns its number, empty if not known
+func (w *WebhookTicketer) Open(key string, body []byte) (string, error) {
+	req := w.Client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Idempotency-Key", key).
+		SetBody(body)
+	if w.Token != "" {
+		req.SetHeader(w.Header, w.Token)
+	}
+
+	resp, err := req.Post(w.URL)
+	if err != nil {
+		return "", errors.Wrap(err, "webhook")
+	}
+	if resp.IsError() {
+		return "", fmt.Errorf("webhook: %s", resp.Status())
+	}
+	if w.ID == "" {
+		return "", nil
+	}
+
+	var answer interface{}
+	if err := json.Unmarshal(resp.Body(), &answer); err != nil {
+		return "", errors.Wrap(err, "webhook answer")
+	}
+	return lookup(answer, w.ID), nil
+}
+
+// lookup follows a dotted path in decoded JSON
+func lookup(v interface{}, path string) string {
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[k]
+	}
+	switch id := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return id
+	default:
+		return fmt.Sprint(id)
+	}
+}
+
+// TicketKey identifies the indicators of a ticket, the same ones always give the same key
+// whatever the files they come from
+func TicketKey(entries []string) string {
+	all := append([]string{}, entries...)
+	sort.Strings(all)
+
+	sum := sha256.Sum256([]byte(strings.Join(all, "\n")))
+	return fmt.Sprintf("cimbl-%x", sum[:16])
+}
+
+// ticketEntries are what a ticket asks to block
+func ticketEntries(res *Results) []string {
+	all := aggregatedKeys(res)
+	for _, m := range []map[string]bool{res.URLs, res.Domains, res.Networks, res.Paths, res.Hashes, res.Unchecked} {
+		all = append(all, keysOf(m)...)
+	}
+	sort.Strings(all)
+	return all
+}
+
+// without returns the part of r not in done
+func (r *Results) without(done map[string]bool) *Results {
+	all := map[string]bool{}
+	for _, t := range IndicatorTypes {
+		all[t] = true
+	}
+	s := r.only(all)
+
+	for _, m := range []map[string]bool{s.URLs, s.Domains, s.Networks, s.Paths, s.Hashes, s.Unchecked} {
+		for k := range m {
+			if done[k] {
+				delete(m, k)
+			}
+		}
+	}
+	for k := range s.Aggregated {
+		if done[k] {
+			delete(s.Aggregated, k)
+		}
+	}
+	for u := range s.Verdicts {
+		if host, ok := httpsHost(u); done[u] || ok && done[host] {
+			delete(s.Verdicts, u)
+		}
+	}
+	return s
+}
+
+// ticketFuncs are the template helpers, json being needed for the body
+var ticketFuncs = map[string]interface{}{
+	"json": func(v interface{}) (string, error) {
+		buf, err := json.Marshal(v)
+		return string(buf), err
+	},
+}
+
+// loadTicketTemplate reads the body template, the default one if none
+func loadTicketTemplate(file string) (*template.Template, error) {
+	str := DefaultTicketTmpl
+	if file != "" {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		str = string(buf)
+	}
+	return template.New("ticket").Funcs(templateFuncs).Funcs(ticketFuncs).Parse(str)
+}
+
+var defaultTicketTemplate = template.Must(loadTicketTemplate(""))
+
+// TicketRecord is a ticket already opened
+type TicketRecord struct {
+	ID   string
+	Time time.Time
+	// Entries are the indicators it asked to block
+	Entries []string `json:",omitempty"`
+}
+
+// loadTickets reads the state, missing means none
+func loadTickets(file string) (map[string]TicketRecord, error) {
+	state := map[string]TicketRecord{}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(buf, &state)
+	return state, err
+}
+
+// lastTicket is the latest ticket with some of entries
+func lastTicket(state map[string]TicketRecord, entries []string) (string, TicketRecord) {
+	want := map[string]bool{}
+	for _, e := range entries {
+		want[e] = true
+	}
+
+	var (
+		key  string
+		last TicketRecord
+	)
+	for k, t := range state {
+		for _, e := range t.Entries {
+			if want[e] && (key == "" || t.Time.After(last.Time)) {
+				key, last = k, t
+				break
+			}
+		}
+	}
+	return key, last
+}
+
+// OpenTicket opens a change request for the indicators of res no ticket has asked for yet.
+// It returns the key and the ticket number, if any, the key being empty if there is nothing
+// to block.
+func OpenTicket(ctx *Context, res *Results) (string, string, error) {
+	if ctx.ticket == nil || ctx.config.Ticket == nil {
+		return "", "", fmt.Errorf("no ticket webhook configured")
+	}
+	tc := ctx.config.Ticket
+
+	if len(ticketEntries(res)) == 0 {
+		verbose("Nothing to block, no ticket")
+		return "", "", nil
+	}
+
+	var state map[string]TicketRecord
+	if tc.State != "" {
+		var err error
+		if state, err = loadTickets(tc.State); err != nil {
+			return "", "", errors.Wrap(err, "ticket state")
+		}
+
+		done := map[string]bool{}
+		for _, t := range state {
+			for _, e := range t.Entries {
+				done[e] = true
+			}
+		}
+		if part := res.without(done); len(ticketEntries(part)) != 0 {
+			res = part
+		} else {
+			key, t := lastTicket(state, ticketEntries(res))
+			log.Printf("Ticket %s already opened for these indicators on %v", t.ID, t.Time)
+			return key, t.ID, nil
+		}
+	}
+
+	entries := ticketEntries(res)
+	key := TicketKey(entries)
+
+	d := newReportData(ctx, res)
+	var report, body bytes.Buffer
+	if err := ctx.getTemplates().text.Execute(&report, d); err != nil {
+		return key, "", errors.Wrap(err, "ticket")
+	}
+	tmpl := ctx.ticketTmpl
+	if tmpl == nil {
+		tmpl = defaultTicketTemplate
+	}
+	if err := tmpl.Execute(&body, TicketData{ReportData: d, Key: key, Report: report.String()}); err != nil {
+		return key, "", errors.Wrap(err, "ticket template")
+	}
+
+	verbose("Opening ticket %s", key)
+	id, err := ctx.ticket.Open(key, body.Bytes())
+	if err != nil {
+		return key, "", errors.Wrap(err, "ticket")
+	}
+
+	if state != nil {
+		state[key] = TicketRecord{ID: id, Time: time.Now(), Entries: entries}
+		buf, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return key, id, errors.Wrap(err, "ticket state")
+		}
+		if err := ioutil.WriteFile(tc.State, buf, 0600); err != nil {
+			return key, id, errors.Wrap(err, "ticket state")
+		}
+	}
+	return key, id, nil
+}
@@ -0,0 +1,192 @@
+package cimbl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ticketServer is a stand-in for the ticketing system, counting the tickets
+func ticketServer(t *testing.T, got *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sekrit" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		body := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		body["Idempotency-Key"] = r.Header.Get("Idempotency-Key")
+		*got = append(*got, body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"number": "CHG0042"}}`))
+	}))
+}
+
+func ticketContext(t *testing.T, tc *TicketConfig) *Context {
+	config, err := LoadConfig("testdata/config.toml")
+	require.NoError(t, err)
+	config.Ticket = tc
+
+	ctx, err := NewContext(config, Options{})
+	require.NoError(t, err)
+	return ctx
+}
+
+func TestOpenTicket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ticket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	got := []map[string]interface{}{}
+	ts := ticketServer(t, &got)
+	defer ts.Close()
+
+	ctx := ticketContext(t, &TicketConfig{URL: ts.URL, Token: "Bearer sekrit", ID: "result.number", State: filepath.Join(dir, "tickets.json")})
+	defer ctx.Cleanup()
+
+	res := SampleResults()
+	key, id, err := OpenTicket(ctx, res)
+	require.NoError(t, err)
+	assert.Equal(t, TicketKey(ticketEntries(res)), key)
+	assert.Equal(t, "CHG0042", id)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, key, got[0]["Idempotency-Key"])
+	assert.Equal(t, key, got[0]["key"])
+	assert.Equal(t, ctx.Config().Subject, got[0]["title"])
+	assert.Contains(t, got[0]["description"], "Dear Service Desk")
+	assert.NotContains(t, got[0]["description"], "Subject:")
+
+	// Rerun, no new ticket
+	key2, id, err := OpenTicket(ctx, SampleResults())
+	require.NoError(t, err)
+	assert.Equal(t, key, key2)
+	assert.Equal(t, "CHG0042", id)
+	assert.Len(t, got, 1)
+}
+
+func TestOpenTicketNewEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ticket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	got := []map[string]interface{}{}
+	ts := ticketServer(t, &got)
+	defer ts.Close()
+
+	ctx := ticketContext(t, &TicketConfig{URL: ts.URL, Token: "Bearer sekrit", State: filepath.Join(dir, "tickets.json")})
+	defer ctx.Cleanup()
+
+	// An IP list, no CIMBL file
+	first := NewResults()
+	first.Add("network", "192.0.2.0/24")
+	key, _, err := OpenTicket(ctx, first)
+	require.NoError(t, err)
+	assert.NotEmpty(t, key)
+
+	// Rerun with more, only the new ones
+	second := NewResults()
+	second.Add("network", "192.0.2.0/24")
+	second.Add("url", "http://example.com/malware")
+	key2, _, err := OpenTicket(ctx, second)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, key2)
+	require.Len(t, got, 2)
+	assert.Contains(t, got[1]["description"], "http://example.com/malware")
+	assert.NotContains(t, got[1]["description"], "192.0.2.0/24")
+
+	// Another list with the same indicators
+	third := NewResults()
+	third.Add("url", "http://example.com/malware")
+	key3, _, err := OpenTicket(ctx, third)
+	require.NoError(t, err)
+	assert.Equal(t, key2, key3)
+	assert.Len(t, got, 2)
+
+	// Nothing to block
+	key, _, err = OpenTicket(ctx, NewResults().AddAllowed("www.microsoft.com", "domain microsoft.com"))
+	require.NoError(t, err)
+	assert.Empty(t, key)
+	assert.Len(t, got, 2)
+}
+
+func TestOpenTicketTemplate(t *testing.T) {
+	got := []map[string]interface{}{}
+	ts := ticketServer(t, &got)
+	defer ts.Close()
+
+	ctx := ticketContext(t, &TicketConfig{URL: ts.URL, Token: "Bearer sekrit", Template: "testdata/ticket.tmpl"})
+	defer ctx.Cleanup()
+
+	_, id, err := OpenTicket(ctx, SampleResults())
+	require.NoError(t, err)
+	assert.Empty(t, id)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, float64(1), got[0]["urls"])
+	assert.Equal(t, []interface{}{"CIMBL-0666-CERTS.csv", "CIMBL-0667-CERTS.zip"}, got[0]["files"])
+	assert.Equal(t, got[0]["Idempotency-Key"], got[0]["correlation_id"])
+}
+
+func TestOpenTicketErrors(t *testing.T) {
+	got := []map[string]interface{}{}
+	ts := ticketServer(t, &got)
+	defer ts.Close()
+
+	// Bad token
+	ctx := ticketContext(t, &TicketConfig{URL: ts.URL, Token: "Bearer nope"})
+	defer ctx.Cleanup()
+
+	_, _, err := OpenTicket(ctx, SampleResults())
+	assert.Error(t, err)
+	assert.Empty(t, got)
+
+	// Not configured
+	_, _, err = OpenTicket(&Context{config: &Config{}}, SampleResults())
+	assert.Error(t, err)
+
+	// Bad state
+	ctx = ticketContext(t, &TicketConfig{URL: ts.URL, Token: "Bearer sekrit", State: "testdata/bad.csv"})
+	defer ctx.Cleanup()
+
+	_, _, err = OpenTicket(ctx, SampleResults())
+	assert.Error(t, err)
+	assert.Empty(t, got)
+}
+
+func TestNewContextTicket(t *testing.T) {
+	_, err := NewContext(&Config{Ticket: &TicketConfig{}}, Options{})
+	assert.Error(t, err)
+
+	_, err = NewContext(&Config{Ticket: &TicketConfig{URL: "http://localhost", Template: "/nonexistent"}}, Options{})
+	assert.Error(t, err)
+
+	_, err = NewContext(&Config{Ticket: &TicketConfig{URL: "http://localhost", Template: "testdata/broken.tmpl"}}, Options{})
+	assert.Error(t, err)
+}
+
+func TestTicketKey(t *testing.T) {
+	k := TicketKey([]string{"http://example.com/malware", "192.0.2.0/24"})
+	assert.Equal(t, k, TicketKey([]string{"192.0.2.0/24", "http://example.com/malware"}))
+	assert.NotEqual(t, k, TicketKey([]string{"192.0.2.0/24"}))
+}
+
+func TestLookup(t *testing.T) {
+	var v interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"result": {"number": "CHG0042", "id": 42}, "list": [1]}`), &v))
+
+	assert.Equal(t, "CHG0042", lookup(v, "result.number"))
+	assert.Equal(t, "42", lookup(v, "result.id"))
+	assert.Empty(t, lookup(v, "result.none"))
+	assert.Empty(t, lookup(v, "list.first"))
+}
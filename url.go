@@ -1,24 +1,30 @@
-package main
+package cimbl
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/pkg/errors"
 )
 
 const (
-	ActionAuth    = "AUTH"
-	ActionBlock   = "**BLOCK**"
-	ActionBlocked = "BLOCKED-EEC"
+	ActionAuth      = "AUTH"
+	ActionBlock     = "**BLOCK**"
+	ActionBlocked   = "BLOCKED-EEC"
+	ActionUnchecked = "UNCHECKED"
 )
 
 var (
+	ErrHttps      = errors.New("https URL, host-level check")
 	ErrHttpsSkip  = errors.New("skipping https")
 	ErrParseError = errors.New("error parsing URL")
 )
@@ -36,92 +42,172 @@ func checkForIP(str string) net.IP {
 	return net.ParseIP(str)
 }
 
+// sanitize returns the canonical form of str, see Canonicalize
 func sanitize(str string) (out string, err error) {
-	// We do not try to see if there is an error because of some corner cases
-	myurl, err := url.Parse(str)
+	c, err := Canonicalize(str)
 	if err != nil {
-		str = "http://" + str
-		myurl, err = url.Parse(str)
+		debug("str=%s: %v", str, err)
+		return "", ErrParseError
 	}
 
-	if myurl == nil {
-		debug("str=%s myurl is null", str)
-		return "", ErrParseError
+	// Onion sites are not reachable except within Tor
+	if strings.HasSuffix(c.Host, ".onion") {
+		return c.URL, ErrHttpsSkip
 	}
 
-	// We do not test https
-	if myurl.Scheme == "https" {
-		return str, ErrHttpsSkip
+	// https can only be checked at the host level, see checkHTTPS
+	if c.Scheme == "https" {
+		return c.URL, ErrHttps
 	}
+	return c.URL, nil
+}
+
+func handleURL(ctx *Context, c *resty.Client, str string) (string, error) {
+	v, err := checkURL(ctx, c, str)
+	return v.Action, err
+}
 
-	// If a special scheme, transform it into http for convenience
-	if myurl.Scheme != "http" {
-		myurl.Scheme = "http"
+// checkURL probes str through the proxy, Action being str means "block it"
+func checkURL(ctx *Context, c *resty.Client, str string) (Verdict, error) {
+
+	//debug("before,url=%s", str)
+
+	if ctx.opts.NoURLs {
+		return Verdict{}, nil
 	}
 
-	// If host is empty, we might have IP or [IP] or bare hostname
-	if myurl.Host == "" {
-		//
-		if ip := checkForIP(myurl.Path); ip != nil {
-			myurl.Host = ip.String()
-			myurl.Path = ""
-		} else {
-			// Path is actually Host/Path
-			u, _ := url.Parse(myurl.Path)
-			myurl.Host = u.Host
-			myurl.Path = u.Path
-		}
-		return myurl.String(), nil
+	myurl, err := sanitize(str)
+	switch err {
+	case ErrHttps:
+		return checkHTTPS(ctx, c, str)
+	case ErrHttpsSkip, ErrParseError:
+		return Verdict{}, err
 	}
+	debug("url=%s", myurl)
 
-	// Onion sites are not reachable except within Tor
-	if strings.Contains(myurl.Host, ".onion") {
-		return str, ErrHttpsSkip
+	a, err := ctx.getProber().Probe(ctx, c, myurl)
+	if err != nil {
+		return Verdict{Method: a.Method}, errors.Wrap(err, "probe")
 	}
 
-	// check for [IP]
-	l := len(myurl.Host)
-	if myurl.Host[0] == '[' && myurl.Host[l-1] == ']' {
-		myurl.Host = myurl.Host[1 : l-1]
+	return Verdict{Action: verdict(ctx, a, str), Method: a.Method, Code: a.Code}, nil
+}
+
+// hostOf returns the host part of an URL, used to group requests
+func hostOf(str string) string {
+	myurl, err := url.Parse(str)
+	if err != nil {
+		return str
 	}
-	return myurl.String(), err
+	return myurl.Hostname()
 }
 
-func handleURL(c *resty.Client, str string) (string, error) {
+// httpsHost returns the host part of an https URL, it is what we can ask to block
+// as the path is not visible to the proxy without MITM.
+func httpsHost(str string) (string, bool) {
+	myurl, err := url.Parse(str)
+	if err != nil || myurl.Scheme != "https" {
+		return "", false
+	}
+	return myurl.Hostname(), true
+}
 
-	//debug("before,url=%s", str)
+// proxyFor returns the proxy used by the client for this URL, nil if none
+func proxyFor(c *resty.Client, myurl *url.URL) *url.URL {
+	t, ok := c.GetClient().Transport.(*http.Transport)
+	if !ok || t.Proxy == nil {
+		return nil
+	}
+	proxy, err := t.Proxy(&http.Request{URL: myurl})
+	if err != nil || proxy == nil || proxy.Host == "" {
+		return nil
+	}
+	return proxy
+}
 
-	if fNoURLs {
-		return "", nil
+// checkHTTPS checks an https URL at the host level with a CONNECT through the proxy.
+// Without a proxy there is nothing to probe and the host is to be blocked.
+func checkHTTPS(ctx *Context, c *resty.Client, str string) (Verdict, error) {
+	myurl, err := url.Parse(str)
+	if err != nil {
+		return Verdict{}, ErrParseError
 	}
 
-	// https URLs will not be blocked, no MITM
-	myurl, err := sanitize(str)
-	if err == ErrHttpsSkip {
-		return "", err
+	hostport := myurl.Host
+	if myurl.Port() == "" {
+		hostport = net.JoinHostPort(myurl.Hostname(), "443")
+	}
+	debug("https=%s", hostport)
+
+	proxy := proxyFor(c, myurl)
+	if proxy == nil {
+		verbose("no proxy, can not check %s", hostport)
+		return Verdict{Action: str}, nil
 	}
-	debug("url=%s", myurl)
 
-	resp, err := c.R().
-		SetHeader("User-Agent", fmt.Sprintf("%s/%s", MyName, MyVersion)).
-		Head(myurl)
+	a, err := ctx.throttle.Do(ctx.Run(), myurl.Hostname(), func() (answer, error) {
+		return connectProbe(ctx.Run(), ctx.auth, c, proxy, hostport)
+	})
 	if err != nil {
-		return "", errors.Wrap(err, "Head")
-	}
-
-	switch resp.StatusCode() {
-	// Error (blocked port etc.)
-	case http.StatusServiceUnavailable:
-		fallthrough
-	// Already blocked
-	case http.StatusForbidden:
-		return ActionBlocked, nil
-	// Missing a parameter
-	case http.StatusProxyAuthRequired:
-		log.Printf("Auth required")
-		return ActionAuth, err
-	// Block it already!
-	default:
-		return str, nil
+		return Verdict{Method: http.MethodConnect}, errors.Wrap(err, "connect")
 	}
+	return Verdict{Action: verdict(ctx, a, str), Method: http.MethodConnect, Code: a.Code}, nil
+}
+
+// connectProbe sends a CONNECT for hostport to the proxy and returns its answer, pa
+// being used if the proxy asks for authentication
+func connectProbe(ctx context.Context, pa Authenticator, c *resty.Client, proxy *url.URL, hostport string) (answer, error) {
+	timeout := c.GetClient().Timeout
+
+	d := &net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return answer{}, errors.Wrap(err, "dial")
+	}
+	defer conn.Close()
+
+	if timeout != 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	// Unblock the read if we are cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	// Handshakes like NTLM need the same connection
+	br := bufio.NewReader(conn)
+
+	return authenticate(pa, func(auth string) (answer, error) {
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: hostport},
+			Host:   hostport,
+			Header: http.Header{},
+		}
+		req.Header.Set("User-Agent", fmt.Sprintf("%s/%s", MyName, MyVersion))
+		if auth != "" {
+			req.Header.Set("Proxy-Authorization", auth)
+		}
+
+		if err := req.Write(conn); err != nil {
+			return answer{}, errors.Wrap(err, "write")
+		}
+
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return answer{}, errors.Wrap(err, "read")
+		}
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(ioutil.Discard, resp.Body)
+		}
+		resp.Body.Close()
+		return answer{Method: http.MethodConnect, Code: resp.StatusCode, Header: resp.Header}, nil
+	})
 }
@@ -1,8 +1,10 @@
-package main
+package cimbl
 
 import (
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"testing"
@@ -23,18 +25,21 @@ func TestSanitize(t *testing.T) {
 		res string
 		err error
 	}{
-		{"https://example.com", "https://example.com", ErrHttpsSkip},
-		{"http://example.onion", "http://example.onion", ErrHttpsSkip},
-		{"http://example.onion:3636", "http://example.onion:3636", ErrHttpsSkip},
-		{"http://example.com", "http://example.com", nil},
-		{"ttp://example.com", "http://example.com", nil},
-		{"://example.com", "http://://example.com", nil},
-		{"example.com", "http://example.com", nil},
+		{"https://example.com", "https://example.com/", ErrHttps},
+		{"https://example.onion", "https://example.onion/", ErrHttpsSkip},
+		{"http://example.onion", "http://example.onion/", ErrHttpsSkip},
+		{"http://example.onion:3636", "http://example.onion:3636/", ErrHttpsSkip},
+		{"http://example.com", "http://example.com/", nil},
+		{"ttp://example.com", "http://example.com/", nil},
+		{"://example.com", "http://example.com/", nil},
+		{"example.com", "http://example.com/", nil},
 		{"example.com/foo.php", "http://example.com/foo.php", nil},
-		{"http://[1.2.3.4]", "http://1.2.3.4", nil},
-		{"[1.2.3.4]", "http://1.2.3.4", nil},
-		{"103.15.234.152:80/index.php", "http://103.15.234.152:80/index.php", nil},
-		{":/--%2Fexample.com", "http://:/--%2Fexample.com", nil},
+		{"http://[1.2.3.4]", "http://1.2.3.4/", nil},
+		{"[1.2.3.4]", "http://1.2.3.4/", nil},
+		{"103.15.234.152:80/index.php", "http://103.15.234.152/index.php", nil},
+		{":/--%2Fexample.com", "", ErrParseError},
+		{"hxxp://www[.]example[.]com/foo.php", "http://www.example.com/foo.php", nil},
+		{"ftp://example.com", "", ErrParseError},
 		{"https://jtabserver.org/bins/jayct.vbs&amp#39;,&amp;#39;%ALLUSERSPROFILE%\\jayct.vbs&amp;quot;", "", ErrParseError},
 	}
 	for _, u := range urls {
@@ -67,16 +72,71 @@ func TestCheckForIP(t *testing.T) {
 func TestHandleURLhttps(t *testing.T) {
 	defer gock.Off()
 
-	skipped = []string{}
-
 	proxy := os.Getenv("http_proxy")
 	c := resty.New().SetProxy(proxy)
 
-	u, err := handleURL(c, "https://example.com")
+	u, err := handleURL(&Context{}, c, "https://example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com", u)
+}
+
+func TestHandleURLhttpsOnion(t *testing.T) {
+	c := resty.New()
+
+	u, err := handleURL(&Context{}, c, "https://example.onion")
 	assert.Error(t, err)
 	assert.Empty(t, u)
 }
 
+// newConnectProxy fakes a proxy answering CONNECT with code
+func newConnectProxy(t *testing.T, code int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodConnect, r.Method)
+		assert.Equal(t, "example.com:443", r.Host)
+		w.WriteHeader(code)
+	}))
+}
+
+func TestHandleURLhttpsBlocked(t *testing.T) {
+	proxy := newConnectProxy(t, http.StatusForbidden)
+	defer proxy.Close()
+
+	c := resty.New().SetProxy(proxy.URL)
+
+	u, err := handleURL(&Context{}, c, "https://example.com/malware.exe")
+	assert.NoError(t, err)
+	assert.Equal(t, ActionBlocked, u)
+}
+
+func TestHandleURLhttpsBlock(t *testing.T) {
+	proxy := newConnectProxy(t, http.StatusOK)
+	defer proxy.Close()
+
+	c := resty.New().SetProxy(proxy.URL)
+
+	u, err := handleURL(&Context{}, c, "https://example.com/malware.exe")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/malware.exe", u)
+}
+
+func TestHandleURLhttpsNoProxy(t *testing.T) {
+	c := resty.New().SetProxy("http://127.0.0.1:1")
+
+	u, err := handleURL(&Context{}, c, "https://example.com/malware.exe")
+	assert.Error(t, err)
+	assert.Empty(t, u)
+}
+
+func TestHttpsHost(t *testing.T) {
+	h, ok := httpsHost("https://example.com:8443/foo")
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", h)
+
+	h, ok = httpsHost("http://example.com/foo")
+	assert.False(t, ok)
+	assert.Empty(t, h)
+}
+
 func TestHandleURLblocked(t *testing.T) {
 	defer gock.Off()
 
@@ -94,7 +154,7 @@ func TestHandleURLblocked(t *testing.T) {
 	gock.InterceptClient(c.GetClient())
 	defer gock.RestoreClient(c.GetClient())
 
-	u, err := handleURL(c, TestSite)
+	u, err := handleURL(&Context{}, c, TestSite)
 	assert.NoError(t, err)
 	require.EqualValues(t, ActionBlocked, u)
 }
@@ -116,7 +176,7 @@ func TestHandleURLAuth(t *testing.T) {
 	gock.InterceptClient(c.GetClient())
 	defer gock.RestoreClient(c.GetClient())
 
-	u, err := handleURL(c, TestSite)
+	u, err := handleURL(&Context{}, c, TestSite)
 	assert.NoError(t, err)
 	require.EqualValues(t, ActionAuth, u)
 }
@@ -138,7 +198,7 @@ func TestHandleURLNope(t *testing.T) {
 	gock.InterceptClient(c.GetClient())
 	defer gock.RestoreClient(c.GetClient())
 
-	u, err := handleURL(c, TestSite)
+	u, err := handleURL(&Context{}, c, TestSite)
 	assert.NoError(t, err)
 	require.EqualValues(t, ActionBlocked, u)
 }
@@ -160,22 +220,19 @@ func TestHandleURLblock(t *testing.T) {
 	gock.InterceptClient(c.GetClient())
 	defer gock.RestoreClient(c.GetClient())
 
-	u, err := handleURL(c, TestSite)
+	u, err := handleURL(&Context{}, c, TestSite)
 	assert.NoError(t, err)
 	require.NotEmpty(t, u)
 	assert.Equal(t, u, TestSite)
 }
 
 func TestHandleURLno(t *testing.T) {
-
 	proxy := os.Getenv("http_proxy")
 	c := resty.New().SetProxy(proxy)
 
-	fNoURLs = true
+	ctx := &Context{opts: Options{NoURLs: true}}
 
-	u, err := handleURL(c, TestSite)
+	u, err := handleURL(ctx, c, TestSite)
 	assert.NoError(t, err)
 	require.Empty(t, u)
-
-	fNoURLs = false
 }
@@ -1,18 +1,33 @@
-package main
+package cimbl
 
 import "log"
 
-// debug displays only if fDebug is set
+var (
+	// logDebug & logVerbose are set by the caller, see SetDebug & SetVerbose
+	logDebug   bool
+	logVerbose bool
+)
+
+// SetDebug enables the debug messages
+func SetDebug(on bool) {
+	logDebug = on
+}
+
+// SetVerbose enables the informational messages
+func SetVerbose(on bool) {
+	logVerbose = on
+}
+
+// debug displays only if logDebug is set
 func debug(str string, a ...interface{}) {
-    if fDebug {
-        log.Printf(str, a...)
-    }
+	if logDebug {
+		log.Printf(str, a...)
+	}
 }
 
-// debug displays only if fVerbose is set
+// verbose displays only if logVerbose is set
 func verbose(str string, a ...interface{}) {
-    if fVerbose {
-        log.Printf(str, a...)
-    }
+	if logVerbose {
+		log.Printf(str, a...)
+	}
 }
-
@@ -0,0 +1,183 @@
+package cimbl
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+// Verdicts as used in the configuration file
+const (
+	VerdictBlock   = "block"
+	VerdictBlocked = "blocked"
+	VerdictAuth    = "auth"
+	VerdictIgnore  = "ignore"
+)
+
+// Rule maps a proxy answer to a verdict, all non-empty criteria must match.
+//
+// [[rule]]
+// status = [302]
+// location = "notify\\.example\\.com"
+// verdict = "blocked"
+type Rule struct {
+	Status   []int
+	Header   string
+	Match    string
+	Location string
+	Body     string
+	Verdict  string
+}
+
+// Verdict is what we found for one URL and how
+type Verdict struct {
+	Action string
+	Method string
+	Code   int
+	// Rule is the blocklist entry covering it, if any
+	Rule string `json:",omitempty"`
+}
+
+// answer is what we got from the proxy
+type answer struct {
+	Method string
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+// rule is the compiled version of Rule
+type rule struct {
+	status   map[int]bool
+	header   string
+	match    *regexp.Regexp
+	location *regexp.Regexp
+	body     *regexp.Regexp
+	verdict  string
+}
+
+var (
+	// Redirects are not followed, proxies use them for notification pages
+	keepRedirects = resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	})
+
+	// Default rules, the historical behaviour
+	defaultRules = []Rule{
+		{Status: []int{http.StatusServiceUnavailable, http.StatusForbidden}, Verdict: VerdictBlocked},
+		{Status: []int{http.StatusProxyAuthRequired}, Verdict: VerdictAuth},
+	}
+
+	// builtinRules are used when the context has none
+	builtinRules = mustCompileRules(defaultRules)
+)
+
+func compileRe(str string) (*regexp.Regexp, error) {
+	if str == "" {
+		return nil, nil
+	}
+	return regexp.Compile(str)
+}
+
+// compileRules checks and compiles the rules from the configuration
+func compileRules(cr []Rule) ([]*rule, error) {
+	var err error
+
+	all := make([]*rule, 0, len(cr))
+	for i, r := range cr {
+		switch r.Verdict {
+		case VerdictBlock, VerdictBlocked, VerdictAuth, VerdictIgnore:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown verdict %q", i, r.Verdict)
+		}
+		// A rule without criteria would match every answer
+		if len(r.Status) == 0 && r.Header == "" && r.Location == "" && r.Body == "" {
+			return nil, fmt.Errorf("rule %d (%s): no criteria", i, r.Verdict)
+		}
+		if r.Match != "" && r.Header == "" {
+			return nil, fmt.Errorf("rule %d (%s): match needs a header", i, r.Verdict)
+		}
+
+		nr := &rule{
+			status:  map[int]bool{},
+			header:  http.CanonicalHeaderKey(r.Header),
+			verdict: r.Verdict,
+		}
+		for _, s := range r.Status {
+			nr.status[s] = true
+		}
+		if nr.match, err = compileRe(r.Match); err != nil {
+			return nil, errors.Wrapf(err, "rule %d: match", i)
+		}
+		if nr.location, err = compileRe(r.Location); err != nil {
+			return nil, errors.Wrapf(err, "rule %d: location", i)
+		}
+		if nr.body, err = compileRe(r.Body); err != nil {
+			return nil, errors.Wrapf(err, "rule %d: body", i)
+		}
+		all = append(all, nr)
+	}
+	return all, nil
+}
+
+func mustCompileRules(cr []Rule) []*rule {
+	all, err := compileRules(cr)
+	if err != nil {
+		panic(err)
+	}
+	return all
+}
+
+// matches checks every criteria set in the rule
+func (r *rule) matches(a answer) bool {
+	if len(r.status) != 0 && !r.status[a.Code] {
+		return false
+	}
+	if r.header != "" {
+		v, ok := a.Header[r.header]
+		if !ok {
+			return false
+		}
+		if r.match != nil && !r.match.MatchString(strings.Join(v, ",")) {
+			return false
+		}
+	}
+	if r.location != nil && !r.location.MatchString(a.Header.Get("Location")) {
+		return false
+	}
+	if r.body != nil && !r.body.Match(a.Body) {
+		return false
+	}
+	return true
+}
+
+// verdict maps the proxy answer to an action, str meaning "block it"
+// First matching rule wins, default is to block.
+func verdict(ctx *Context, a answer, str string) string {
+	for _, r := range ctx.getRules() {
+		if !r.matches(a) {
+			continue
+		}
+		debug("rule %#v matched %d", r, a.Code)
+		switch r.verdict {
+		// Already blocked
+		case VerdictBlocked:
+			return ActionBlocked
+		// Missing a parameter
+		case VerdictAuth:
+			log.Printf("Auth required")
+			return ActionAuth
+		case VerdictIgnore:
+			return ""
+		default:
+			return str
+		}
+	}
+	// Block it already!
+	return str
+}
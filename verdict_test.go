@@ -0,0 +1,133 @@
+package cimbl
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rulesContext returns a context with the rules from file
+func rulesContext(t *testing.T, file string) *Context {
+	config, err := LoadConfig(filepath.Join("testdata", file))
+	require.NoError(t, err)
+
+	rules, err := compileRules(append(config.Rules, defaultRules...))
+	require.NoError(t, err)
+	return &Context{rules: rules}
+}
+
+func TestCompileRules(t *testing.T) {
+	r, err := compileRules(defaultRules)
+	assert.NoError(t, err)
+	assert.Len(t, r, 2)
+}
+
+func TestCompileRulesBadVerdict(t *testing.T) {
+	r, err := compileRules([]Rule{{Verdict: "maybe"}})
+	assert.Error(t, err)
+	assert.Nil(t, r)
+}
+
+func TestCompileRulesNoCriteria(t *testing.T) {
+	_, err := compileRules([]Rule{{Verdict: VerdictBlocked}})
+	assert.Error(t, err)
+
+	_, err = compileRules([]Rule{{Status: []int{200}, Match: "squid", Verdict: VerdictBlocked}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "rule 0")
+}
+
+func TestCompileRulesBadRe(t *testing.T) {
+	r, err := compileRules([]Rule{{Body: "(", Verdict: VerdictBlocked}})
+	assert.Error(t, err)
+	assert.Nil(t, r)
+}
+
+func TestLoadConfigRules(t *testing.T) {
+	c, err := LoadConfig("testdata/config-rules.toml")
+	require.NoError(t, err)
+	require.Len(t, c.Rules, 4)
+	assert.Equal(t, Rule{Status: []int{302}, Location: `notify\.example\.com`, Verdict: VerdictBlocked}, c.Rules[0])
+}
+
+func TestNewContextBadRules(t *testing.T) {
+	config, err := LoadConfig("testdata/bad-rules.toml")
+	require.NoError(t, err)
+
+	ctx, err := NewContext(config, Options{})
+	assert.Error(t, err)
+	assert.Nil(t, ctx)
+}
+
+func TestVerdictDefault(t *testing.T) {
+	td := []struct {
+		code int
+		res  string
+	}{
+		{http.StatusOK, TestSite},
+		{http.StatusNotFound, TestSite},
+		{http.StatusFound, TestSite},
+		{http.StatusForbidden, ActionBlocked},
+		{http.StatusServiceUnavailable, ActionBlocked},
+		{http.StatusProxyAuthRequired, ActionAuth},
+	}
+	for _, d := range td {
+		assert.Equal(t, d.res, verdict(&Context{}, answer{Code: d.code}, TestSite))
+	}
+}
+
+func TestVerdictBody(t *testing.T) {
+	ctx := rulesContext(t, "config-rules.toml")
+
+	a := answer{Code: 200, Body: []byte("<h1>Access Denied</h1>")}
+	assert.Equal(t, ActionBlocked, verdict(ctx, a, TestSite))
+
+	a = answer{Code: 200, Body: []byte("<h1>Welcome</h1>")}
+	assert.Equal(t, TestSite, verdict(ctx, a, TestSite))
+}
+
+func TestHandleURLRules(t *testing.T) {
+	ctx := rulesContext(t, "config-rules.toml")
+
+	testSite, err := url.Parse(TestSite)
+	require.NoError(t, err)
+
+	td := []struct {
+		name   string
+		code   int
+		header map[string]string
+		res    string
+	}{
+		{"notify", 302, map[string]string{"Location": "http://notify.example.com/blocked"}, ActionBlocked},
+		{"redirect", 302, map[string]string{"Location": "http://example.net/other.php"}, TestSite},
+		{"header", 200, map[string]string{"X-Blocked-By": "BlueCoat ProxySG"}, ActionBlocked},
+		{"header-other", 200, map[string]string{"X-Blocked-By": "nobody"}, TestSite},
+		{"gone", 404, nil, ""},
+		{"forbidden", 403, nil, ActionBlocked},
+		{"ok", 200, nil, TestSite},
+	}
+
+	for _, d := range td {
+		c := resty.New().SetRedirectPolicy(keepRedirects)
+
+		gock.New(testSite.Host).
+			Head(testSite.Path).
+			Reply(d.code).
+			SetHeaders(d.header)
+
+		gock.InterceptClient(c.GetClient())
+
+		u, err := handleURL(ctx, c, TestSite)
+		assert.NoError(t, err, d.name)
+		assert.Equal(t, d.res, u, d.name)
+
+		gock.RestoreClient(c.GetClient())
+		gock.Off()
+	}
+}